@@ -3,7 +3,6 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -27,30 +26,25 @@ var ageCmd = &cobra.Command{
 		fmt.Fprintln(w, "----\t----\t----------\t---")
 
 		now := time.Now()
-		exitCode := 0
+		var exitCodes []int
 
 		for _, backup := range config.Backups {
-			data := ""
-			if backup.DataStdinCommand == "" {
-				data = strings.Join(backup.Data, ",")
-			} else {
-				data = backup.DataStdinCommand
-			}
+			data := backupData(backup)
 
-			for _, repo := range backup.Repositories {
+			for _, repo := range backup.GetTargetRepositories() {
 				repository := config.GetRepositoryByName(repo)
 
 				if repository == nil {
 					fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repo)
-					os.Exit(1)
+					os.Exit(exitConfigError)
 				}
 
 				lastBackupTimestamp, err := restic.GetLastBackupTimestamp(backup, *repository)
 
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "Failed to get age for backup %s: %s\n", backup.Name, err)
-					exitCode = 1
 				}
+				exitCodes = append(exitCodes, exitCodeForError(err))
 
 				age := "-"
 				if (lastBackupTimestamp != time.Time{}) {
@@ -65,6 +59,6 @@ var ageCmd = &cobra.Command{
 		}
 
 		w.Flush()
-		os.Exit(exitCode)
+		os.Exit(combineExitCodes(exitCodes))
 	},
 }
@@ -1,9 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/fgma/rester/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -21,25 +23,61 @@ var backupCmd = &cobra.Command{
 	},
 }
 
+// conditionResults holds the result of checking the conditions of each backup
+// so they are checked and the backup is delayed once and not once for each of
+// its repositories.
+var conditionResults = make(map[string]error)
+
+func checkBackupConditions(backup internal.Backup) error {
+
+	if err, ok := conditionResults[backup.Name]; ok {
+		return err
+	}
+
+	err := restic.CheckConditions(backup)
+	conditionResults[backup.Name] = err
+	return err
+}
+
 func runBackup(backupName string, repositoryName string) (int, error) {
 
 	backup := config.GetBackupByName(backupName)
 
 	if backup == nil {
 		fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
 	repository := config.GetRepositoryByName(repositoryName)
 
 	if repository == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
+	}
+
+	var copyTargets []internal.Repository
+
+	for _, repo := range backup.CopyTo {
+		target := config.GetRepositoryByName(repo)
+
+		if target == nil {
+			fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repo)
+			os.Exit(exitConfigError)
+		}
+
+		copyTargets = append(copyTargets, *target)
+	}
+
+	err := checkBackupConditions(*backup)
+	if err == nil {
+		err = restic.RunBackup(*backup, *repository, copyTargets)
 	}
 
-	if err := restic.RunBackup(*backup, *repository); err != nil {
+	if errors.Is(err, internal.ErrBackupSkipped) {
+		fmt.Printf("Backup %s %s\n", backupName, err.Error())
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Backup %s failed to run: %s\n", backupName, err.Error())
 	}
 
-	return 0, nil
+	return exitCodeForError(err), nil
 }
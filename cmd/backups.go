@@ -6,6 +6,7 @@ import (
 	"strings"
 	"text/tabwriter"
 
+	"github.com/fgma/rester/internal"
 	"github.com/spf13/cobra"
 )
 
@@ -22,22 +23,64 @@ var backupsCmd = &cobra.Command{
 
 		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 
-		fmt.Fprintln(w, "name\tdata\trepositories")
-		fmt.Fprintln(w, "----\t----\t----------")
+		fmt.Fprintln(w, "name\tdata\texcludes\trepositories")
+		fmt.Fprintln(w, "----\t----\t--------\t------------")
 
 		for _, backup := range config.Backups {
-			data := ""
-			if backup.DataStdinCommand == "" {
-				data = strings.Join(backup.Data, ",")
-			} else {
-				data = backup.DataStdinCommand
-			}
+			data := backupData(backup)
 			fmt.Fprintf(
-				w, "%s\t%s\t%s\n",
-				backup.Name, data, strings.Join(backup.Repositories, ","),
+				w, "%s\t%s\t%s\t%s\n",
+				backup.Name, data, backupExcludes(backup), strings.Join(backup.Repositories, ","),
 			)
 		}
 
 		w.Flush()
 	},
 }
+
+// backupData describes what a backup saves: its paths or its stdin commands.
+func backupData(backup internal.Backup) string {
+	sources := backup.GetStdinSources()
+	if len(sources) == 0 {
+		data := append([]string{}, backup.Data...)
+		for _, filesFrom := range backup.FilesFrom {
+			data = append(data, "files-from:"+filesFrom)
+		}
+		for _, filesFrom := range backup.FilesFromVerbatim {
+			data = append(data, "files-from-verbatim:"+filesFrom)
+		}
+		return strings.Join(data, ",")
+	}
+
+	commands := make([]string, len(sources))
+	for i, source := range sources {
+		commands[i] = source.Command
+	}
+	return strings.Join(commands, ",")
+}
+
+// backupExcludes describes all excludes of a backup or returns "-" if there are none.
+func backupExcludes(backup internal.Backup) string {
+	excludes := append([]string{}, backup.Exclude...)
+
+	for _, iexclude := range backup.IExclude {
+		excludes = append(excludes, "iexclude:"+iexclude)
+	}
+	for _, excludeFile := range backup.ExcludeFile {
+		excludes = append(excludes, "exclude-file:"+excludeFile)
+	}
+	for _, excludeIfPresent := range backup.ExcludeIfPresent {
+		excludes = append(excludes, "if-present:"+excludeIfPresent)
+	}
+	if backup.ExcludeCaches {
+		excludes = append(excludes, "caches")
+	}
+	if backup.ExcludeLargerThan != "" {
+		excludes = append(excludes, "larger-than:"+backup.ExcludeLargerThan)
+	}
+
+	if len(excludes) == 0 {
+		return "-"
+	}
+	return strings.Join(excludes, ",")
+}
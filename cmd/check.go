@@ -3,63 +3,114 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"text/tabwriter"
+	"time"
 
+	"github.com/fgma/rester/internal"
 	"github.com/spf13/cobra"
 )
 
+var checkStatus bool
+
 func init() {
 	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().BoolVar(
+		&checkStatus, "status", false,
+		"show how much data has been read by previous checks instead of checking",
+	)
 }
 
 var checkCmd = &cobra.Command{
 	Use:   "check",
 	Short: "Check configured repositories",
-	Long:  `Check configured repositories`,
-	Args:  cobra.ArbitraryArgs,
+	Long: `Check configured repositories. ` +
+		`With read_data_percentage or read_data_size one subset of the data is read on each check, ` +
+		`cycling through all subsets in order.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-
-		ensureRepositoriesExist(args)
-
-		if len(args) == 0 {
-			for _, repo := range config.Repositories {
-				runCheck(repo.Name)
-			}
-		} else {
-			for _, repoName := range args {
-				runCheck(repoName)
-			}
+		if checkStatus {
+			printCheckStatus(args)
+			return
 		}
-
+		runForRepositories(args, runCheck)
 	},
 }
 
-func runCheck(repoName string) {
+func runCheck(repoName string) (int, error) {
 
 	repo := config.GetRepositoryByName(repoName)
 
 	if repo == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured backup\n", repoName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
-	if err := restic.RunCheck(*repo); err != nil {
+	err := restic.RunCheck(*repo)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Check %s failed to run: %s\n", repoName, err.Error())
 	}
+
+	return exitCodeForError(err), nil
 }
 
-func ensureRepositoriesExist(repositories []string) {
+func printCheckStatus(repositories []string) {
+
+	ensureRepositoriesExist(repositories)
+
+	if len(repositories) == 0 {
+		for _, repo := range config.Repositories {
+			repositories = append(repositories, repo.Name)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "repository\tread data\tsubset\tcoverage\tcycle started\tlast check\tlast full read")
+	fmt.Fprintln(w, "----------\t---------\t------\t--------\t-------------\t----------\t--------------")
+
+	var exitCodes []int
+
 	for _, repoName := range repositories {
-		isExistingRepo := false
-		for _, b := range config.Repositories {
-			if b.Name == repoName {
-				isExistingRepo = true
-				break
-			}
+		repository := config.GetRepositoryByName(repoName)
+
+		status, err := restic.GetCheckStatus(*repository)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get check status of %s: %s\n", repoName, err)
 		}
+		exitCodes = append(exitCodes, exitCodeForError(err))
 
-		if !isExistingRepo {
-			fmt.Fprintf(os.Stderr, "%s is not a configured repository\n", repoName)
-			os.Exit(1)
+		subset, coverage := "-", "-"
+		if status.Subsets > 0 {
+			subset = fmt.Sprintf("%d/%d", status.Subset, status.Subsets)
+			coverage = fmt.Sprintf("%.0f%%", status.Coverage())
 		}
+
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			repository.Name, readDataSetting(repository.Check), subset, coverage,
+			formatStatusTime(status.CycleStart), formatStatusTime(status.LastCheck),
+			formatStatusTime(status.LastFullRead),
+		)
+	}
+
+	w.Flush()
+	os.Exit(combineExitCodes(exitCodes))
+}
+
+func readDataSetting(check internal.Check) string {
+	if check.ReadDataPercentage >= 100 {
+		return "all"
+	} else if check.ReadDataPercentage > 0 {
+		return fmt.Sprintf("%d%%", check.ReadDataPercentage)
+	} else if check.ReadDataSize != "" {
+		return check.ReadDataSize
+	}
+	return "-"
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
 	}
+	return t.Local().Format("2006-01-02 15:04")
 }
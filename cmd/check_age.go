@@ -17,7 +17,7 @@ var checkAgeCmd = &cobra.Command{
 	Long:  `Check age of the given backups`,
 	Args:  cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		runForBackupConfigurations(args, runCheckAge)
+		runForBackupTargets(args, runCheckAge)
 	},
 }
 
@@ -27,29 +27,29 @@ func runCheckAge(backupName string, repositoryName string) (int, error) {
 
 	if backup == nil {
 		fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
 	repository := config.GetRepositoryByName(repositoryName)
 
 	if repository == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
 	limitWarn, limitError, err := restic.CheckAge(*backup, *repository)
-	exitCode := 0
+	exitCode := exitSuccess
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error checking age for backup %s to repository %s\n", backup.Name, repository.Name)
-		exitCode = 1
+		exitCode = exitCodeForError(err)
 	} else if limitError {
 		fmt.Fprintf(os.Stderr, "Error limit reached for backup %s to repository %s\n", backup.Name, repository.Name)
-		exitCode = 3
+		exitCode = exitFailure
 	} else if limitWarn {
 		fmt.Fprintf(os.Stdout, "Warning limit reached for backup %s to repository %s\n", backup.Name, repository.Name)
-		exitCode = 2
+		exitCode = exitWarning
 	}
 
-	return exitCode, err
+	return exitCode, nil
 }
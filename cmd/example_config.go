@@ -67,7 +67,8 @@ var exampleConfigCmd = &cobra.Command{
 
 		_, err := internal.LoadFromReader(reader)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to parse example config: %s", err)
+			fmt.Fprintf(os.Stderr, "Failed to parse example config: %s\n", err)
+			os.Exit(exitFailure)
 		} else {
 			fmt.Println(exampleConfig)
 		}
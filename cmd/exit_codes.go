@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fgma/rester/internal"
+)
+
+// Exit codes used by all commands. If a command runs for multiple backups or
+// repositories the exit codes of the single runs are combined using
+// combineExitCodes.
+const (
+	exitSuccess        = 0   // everything ran successfully
+	exitFailure        = 1   // everything failed
+	exitWarning        = 2   // ran successfully but with warnings
+	exitPartialFailure = 3   // some runs failed, a snapshot is incomplete or not copied
+	exitConfigError    = 4   // invalid configuration or command line
+	exitLocked         = 5   // a repository is locked by another process
+	exitSkipped        = 6   // everything skipped as conditions are not met
+	exitInterrupted    = 130 // rester got interrupted by a signal
+)
+
+var interrupted int32
+
+// watchInterrupts catches SIGINT and SIGTERM so the currently running restic
+// command and its handlers may finish. No further backups or repositories are
+// processed afterwards. A second signal exits immediately.
+func watchInterrupts() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		s := <-signals
+		atomic.StoreInt32(&interrupted, 1)
+		fmt.Fprintf(os.Stderr, "Received %s, stopping after current command\n", s)
+
+		<-signals
+		os.Exit(exitInterrupted)
+	}()
+}
+
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) != 0
+}
+
+// exitCodeForError maps an error returned by restic to an exit code.
+func exitCodeForError(err error) int {
+	switch {
+	case err == nil:
+		return exitSuccess
+	case isInterrupted(), errors.Is(err, internal.ErrInterrupted):
+		return exitInterrupted
+	case errors.Is(err, internal.ErrRepositoryLocked):
+		return exitLocked
+	case errors.Is(err, internal.ErrIncompleteSnapshot), errors.Is(err, internal.ErrCopyFailed):
+		return exitPartialFailure
+	case errors.Is(err, internal.ErrBackupSkipped):
+		return exitSkipped
+	default:
+		return exitFailure
+	}
+}
+
+// combineExitCodes combines the exit codes of multiple runs into one. Skipped
+// runs are ignored unless all runs have been skipped.
+func combineExitCodes(exitCodes []int) int {
+
+	var ranExitCodes []int
+	for _, exitCode := range exitCodes {
+		if exitCode != exitSkipped {
+			ranExitCodes = append(ranExitCodes, exitCode)
+		}
+	}
+
+	if len(exitCodes) > 0 && len(ranExitCodes) == 0 {
+		return exitSkipped
+	}
+
+	exitCodes = ranExitCodes
+
+	for _, exitCode := range exitCodes {
+		if exitCode == exitInterrupted {
+			return exitInterrupted
+		}
+	}
+
+	failed, locked := 0, 0
+	partial, warning := false, false
+
+	for _, exitCode := range exitCodes {
+		switch exitCode {
+		case exitConfigError:
+			return exitConfigError
+		case exitSuccess:
+		case exitWarning:
+			warning = true
+		case exitPartialFailure:
+			partial = true
+		case exitLocked:
+			locked++
+		default:
+			failed++
+		}
+	}
+
+	if len(exitCodes) > 0 && locked == len(exitCodes) {
+		return exitLocked
+	}
+
+	if len(exitCodes) > 0 && failed+locked == len(exitCodes) {
+		return exitFailure
+	}
+
+	if partial || failed+locked > 0 {
+		return exitPartialFailure
+	}
+
+	if warning {
+		return exitWarning
+	}
+
+	return exitSuccess
+}
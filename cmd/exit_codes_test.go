@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/fgma/rester/internal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCombineExitCodes(t *testing.T) {
+	assert.Equal(t, exitSuccess, combineExitCodes([]int{}))
+	assert.Equal(t, exitSuccess, combineExitCodes([]int{exitSuccess, exitSuccess}))
+	assert.Equal(t, exitWarning, combineExitCodes([]int{exitSuccess, exitWarning}))
+	assert.Equal(t, exitFailure, combineExitCodes([]int{exitFailure}))
+	assert.Equal(t, exitFailure, combineExitCodes([]int{exitFailure, exitLocked}))
+	assert.Equal(t, exitLocked, combineExitCodes([]int{exitLocked, exitLocked}))
+	assert.Equal(t, exitPartialFailure, combineExitCodes([]int{exitSuccess, exitFailure}))
+	assert.Equal(t, exitPartialFailure, combineExitCodes([]int{exitWarning, exitLocked}))
+	assert.Equal(t, exitPartialFailure, combineExitCodes([]int{exitPartialFailure}))
+	assert.Equal(t, exitConfigError, combineExitCodes([]int{exitSuccess, exitConfigError}))
+	assert.Equal(t, exitInterrupted, combineExitCodes([]int{exitConfigError, exitInterrupted}))
+	assert.Equal(t, exitSkipped, combineExitCodes([]int{exitSkipped, exitSkipped}))
+	assert.Equal(t, exitSuccess, combineExitCodes([]int{exitSuccess, exitSkipped}))
+	assert.Equal(t, exitFailure, combineExitCodes([]int{exitFailure, exitSkipped}))
+}
+
+func TestExitCodeForError(t *testing.T) {
+	assert.Equal(t, exitSuccess, exitCodeForError(nil))
+	assert.Equal(t, exitFailure, exitCodeForError(errors.New("failed")))
+	assert.Equal(t, exitLocked, exitCodeForError(fmt.Errorf("%w: exit status 1", internal.ErrRepositoryLocked)))
+	assert.Equal(t, exitPartialFailure, exitCodeForError(fmt.Errorf("%w: exit status 3", internal.ErrIncompleteSnapshot)))
+	assert.Equal(t, exitSkipped, exitCodeForError(fmt.Errorf("%w: running on battery", internal.ErrBackupSkipped)))
+	assert.Equal(t, exitPartialFailure, exitCodeForError(fmt.Errorf("%w to repository [offsite]", internal.ErrCopyFailed)))
+	assert.Equal(t, exitInterrupted, exitCodeForError(fmt.Errorf("%w before backing up db.sql", internal.ErrInterrupted)))
+}
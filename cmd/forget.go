@@ -3,46 +3,162 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/fgma/rester/internal"
 	"github.com/spf13/cobra"
 )
 
+var forgetForce bool
+var forgetPreview bool
+
 func init() {
 	rootCmd.AddCommand(forgetCmd)
+	forgetCmd.Flags().BoolVar(
+		&forgetForce, "force", false,
+		"forget even if the forget guard of the repository is violated",
+	)
+	forgetCmd.Flags().BoolVar(
+		&forgetPreview, "preview", false,
+		"show the snapshots kept and removed by the policy without forgetting any",
+	)
 }
 
 var forgetCmd = &cobra.Command{
 	Use:   "forget",
 	Short: "Forget backups in repositories according to policy",
-	Long:  `Forget backups in repositories according to policy`,
-	Args:  cobra.ArbitraryArgs,
+	Long: `Forget backups in repositories according to policy. ` +
+		`Backups with their own policy are forgotten one by one. ` +
+		`Nothing is forgotten if the snapshots to remove violate the forget guard of the repository.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-
-		ensureRepositoriesExist(args)
-
-		if len(args) == 0 {
-			for _, repo := range config.Repositories {
-				runForget(repo.Name)
-			}
-		} else {
-			for _, repoName := range args {
-				runForget(repoName)
-			}
-		}
-
+		runForRepositories(args, runForget)
 	},
 }
 
-func runForget(repoName string) {
+func runForget(repoName string) (int, error) {
 
 	repo := config.GetRepositoryByName(repoName)
 
 	if repo == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured backup\n", repoName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
+	}
+
+	if forgetPreview {
+		return printForgetPreview(*repo)
 	}
 
-	if err := restic.RunForget(*repo); err != nil {
+	err := restic.RunForget(*repo, config.Backups, forgetForce)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Forget %s failed to run: %s\n", repoName, err.Error())
 	}
+
+	return exitCodeForError(err), nil
+}
+
+func printForgetPreview(repository internal.Repository) (int, error) {
+
+	previews, err := restic.PreviewForget(repository, config.Backups)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to preview forget for repository %s: %s\n", repository.Name, err)
+		return exitCodeForError(err), nil
+	}
+
+	if len(previews) == 0 {
+		return exitSuccess, nil
+	}
+
+	fmt.Printf("Forget preview for %s (%s):\n", repository.Name, repository.URL)
+
+	for _, preview := range previews {
+		for _, group := range preview.Groups {
+			printForgetGroup(forgetGroupTitle(preview.Backup, group), group)
+		}
+	}
+
+	fmt.Println()
+
+	return exitSuccess, nil
+}
+
+// printForgetGroup prints the snapshots of the group newest first with the
+// rules of the policy keeping them.
+func printForgetGroup(title string, group internal.ForgetGroup) {
+	fmt.Printf("\n%s:\n\n", title)
+
+	snapshots := append(append([]internal.Snapshot{}, group.Keep...), group.Remove...)
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.After(snapshots[j].Time)
+	})
+
+	removed := make(map[string]bool)
+	for _, snapshot := range group.Remove {
+		removed[snapshot.ID] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "action\tid\ttime\tbackup\treasons")
+	fmt.Fprintln(w, "------\t--\t----\t------\t-------")
+
+	for _, snapshot := range snapshots {
+		action := "keep"
+		reasons := strings.Join(group.KeepReasons(snapshot), ", ")
+		if removed[snapshot.ID] {
+			action = "remove"
+		}
+
+		if reasons == "" {
+			reasons = "-"
+		}
+
+		backup := snapshot.BackupName()
+		if backup == "" {
+			backup = "-"
+		}
+
+		id := snapshot.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+
+		fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\n",
+			action, id, snapshot.Time.Local().Format("2006-01-02 15:04:05"), backup, reasons,
+		)
+	}
+
+	w.Flush()
+	fmt.Printf("\n%d kept, %d removed\n", len(group.Keep), len(group.Remove))
+}
+
+// forgetGroupTitle describes a group of snapshots by the backup it belongs to
+// and the fields restic has grouped it by.
+func forgetGroupTitle(backup string, group internal.ForgetGroup) string {
+	var fields []string
+
+	if backup != "" {
+		fields = append(fields, "backup "+backup)
+	}
+
+	if group.Host != "" {
+		fields = append(fields, "host "+group.Host)
+	}
+
+	if len(group.Paths) > 0 {
+		fields = append(fields, "paths "+strings.Join(group.Paths, ", "))
+	}
+
+	if len(group.Tags) > 0 {
+		fields = append(fields, "tags "+strings.Join(group.Tags, ", "))
+	}
+
+	if len(fields) == 0 {
+		return "All snapshots"
+	}
+
+	return strings.Join(fields, "; ")
 }
@@ -7,41 +7,67 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var initCopyChunkerParams bool
+
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVar(
+		&initCopyChunkerParams, "copy-chunker-params", true,
+		"initialize copy_to repositories with the chunker parameters of the repository copied from",
+	)
 }
 
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize configured repositories using restic",
-	Long:  `Initialize configured repositories using restic`,
-	Args:  cobra.ArbitraryArgs,
+	Long: `Initialize configured repositories using restic. ` +
+		`Repositories backups are copied to using copy_to are initialized after all others ` +
+		`and get the chunker parameters of the repository copied from so copied snapshots deduplicate.`,
+	Args: cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		runForRepositories(initOrder(args), initRepository)
+	},
+}
+
+// initOrder moves repositories snapshots are copied to behind all others as
+// the repository copied from has to exist first.
+func initOrder(repositoryNames []string) []string {
+	if len(repositoryNames) == 0 {
+		for _, repo := range config.Repositories {
+			repositoryNames = append(repositoryNames, repo.Name)
+		}
+	}
 
-		ensureRepositoriesExist(args)
+	var sources, targets []string
 
-		if len(args) == 0 {
-			for _, repository := range config.Repositories {
-				initRepository(repository.Name)
-			}
+	for _, name := range repositoryNames {
+		if config.GetCopySource(name) != nil {
+			targets = append(targets, name)
 		} else {
-			for _, repoName := range args {
-				initRepository(repoName)
-			}
+			sources = append(sources, name)
 		}
+	}
 
-	},
+	return append(sources, targets...)
 }
 
-func initRepository(repoName string) {
+func initRepository(repoName string) (int, error) {
 	repository := config.GetRepositoryByName(repoName)
 
 	if repository == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repoName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
-	if err := restic.Init(*repository); err != nil {
+	chunkerSource := config.GetCopySource(repoName)
+	if !initCopyChunkerParams {
+		chunkerSource = nil
+	}
+
+	err := restic.Init(*repository, chunkerSource)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Init %s failed to run: %s\n", repoName, err.Error())
 	}
+
+	return exitCodeForError(err), nil
 }
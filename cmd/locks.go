@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(locksCmd)
+}
+
+var locksCmd = &cobra.Command{
+	Use:   "locks",
+	Short: "List locks of repositories",
+	Long:  `List current locks of the repositories specified on the commandline or all if no repository is specified`,
+	Args:  cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForRepositories(args, printLocksForRepository)
+	},
+}
+
+func printLocksForRepository(repositoryName string) (int, error) {
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		os.Exit(exitConfigError)
+	}
+
+	locks, err := restic.GetLocks(*repository)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get locks for repository %s\n%s\n", repositoryName, err)
+		return exitCodeForError(err), nil
+	}
+
+	fmt.Printf("Locks for %s (%s):\n\n", repository.Name, repository.URL)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "id\tage\texclusive\thost\tuser\tpid\tstale")
+	fmt.Fprintln(w, "--\t---\t---------\t----\t----\t---\t-----")
+
+	now := time.Now()
+
+	for _, lock := range locks {
+		id := lock.ID
+		if len(id) > 8 {
+			id = id[:8]
+		}
+		fmt.Fprintf(
+			w, "%s\t%s\t%t\t%s\t%s\t%d\t%t\n",
+			id, now.Sub(lock.Time).Round(time.Second), lock.Exclusive,
+			lock.Hostname, lock.Username, lock.PID,
+			lock.IsStale(repository.Lock.StaleAfter.Duration),
+		)
+	}
+
+	w.Flush()
+	fmt.Println()
+
+	return exitSuccess, nil
+}
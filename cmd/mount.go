@@ -28,12 +28,12 @@ var mountCmd = &cobra.Command{
 
 		if repository == nil {
 			fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
-			os.Exit(1)
+			os.Exit(exitConfigError)
 		}
 
 		if err := restic.Mount(*repository, mountPoint); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to mount repository %s\n%s\n", repositoryName, err)
-			os.Exit(1)
+			os.Exit(exitCodeForError(err))
 		}
 
 	},
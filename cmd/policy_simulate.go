@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fgma/rester/internal"
+	"github.com/spf13/cobra"
+)
+
+var simulateBackup string
+var simulateSchedule string
+var simulateSteps int
+
+func init() {
+	policyCmd.AddCommand(policySimulateCmd)
+	policySimulateCmd.Flags().StringVar(
+		&simulateBackup, "backup", "",
+		"simulate the policy of the given backup using only its snapshots",
+	)
+	policySimulateCmd.Flags().StringVar(
+		&simulateSchedule, "schedule", "",
+		"simulate synthetic snapshots e.g. \"hourly for 2 years\" instead of those in the repository",
+	)
+	policySimulateCmd.Flags().IntVar(
+		&simulateSteps, "steps", 12,
+		"number of points in time to show",
+	)
+}
+
+var policySimulateCmd = &cobra.Command{
+	Use:   "simulate <repository>",
+	Short: "Simulate a policy without forgetting any snapshots",
+	Long: `Simulate the policy of a repository or backup. The snapshots of the repository or synthetic snapshots ` +
+		`created according to a schedule are forgotten one after another as if forget ran after each backup. ` +
+		`The snapshots kept over time and at the end are shown. Nothing is forgotten in the repository.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(simulatePolicy(args[0]))
+	},
+}
+
+func simulatePolicy(repositoryName string) int {
+
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		return exitConfigError
+	}
+
+	var backup *internal.Backup
+	policy := repository.Policy
+	description := fmt.Sprintf("repository [%s]", repository.Name)
+
+	if simulateBackup != "" {
+		backup = config.GetBackupByName(simulateBackup)
+
+		if backup == nil {
+			fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", simulateBackup)
+			return exitConfigError
+		}
+
+		if !internal.Contains(backup.GetTargetRepositories(), repository.Name) {
+			fmt.Fprintf(os.Stderr, "Backup %s is not stored in repository %s\n", backup.Name, repository.Name)
+			return exitConfigError
+		}
+
+		policy = backup.GetPolicy(*repository)
+		description = fmt.Sprintf("backup [%s] in repository [%s]", backup.Name, repository.Name)
+	}
+
+	var snapshots []internal.Snapshot
+
+	if simulateSchedule != "" {
+		schedule, err := internal.ParseSchedule(simulateSchedule)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return exitConfigError
+		}
+
+		snapshots, err = schedule.Snapshots(time.Now().Truncate(time.Hour), simulatedSnapshot(backup))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return exitConfigError
+		}
+	} else {
+		var err error
+		snapshots, err = restic.GetSnapshots(*repository, backup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get snapshots for repository %s\n%s\n", repository.Name, err)
+			return exitCodeForError(err)
+		}
+	}
+
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots to simulate the policy of %s with\n", description)
+		return exitSuccess
+	}
+
+	simulation, err := internal.SimulatePolicy(policy, snapshots, simulateSteps)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to simulate policy of %s: %s\n", description, err)
+		return exitConfigError
+	}
+
+	policyArgs := policy.String()
+	if policyArgs == "" {
+		policyArgs = "empty, all snapshots are kept"
+	}
+
+	fmt.Printf("Simulating policy of %s: %s\n\n", description, policyArgs)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "time\tcreated\tkept\toldest kept")
+	fmt.Fprintln(w, "----\t-------\t----\t-----------")
+
+	for _, step := range simulation.Steps {
+		fmt.Fprintf(
+			w, "%s\t%d\t%d\t%s\n",
+			step.Time.Local().Format("2006-01-02 15:04"), step.Created, step.Kept,
+			step.Oldest.Local().Format("2006-01-02 15:04"),
+		)
+	}
+
+	w.Flush()
+
+	for _, group := range simulation.Groups {
+		printForgetGroup(forgetGroupTitle("", group), group)
+	}
+
+	fmt.Println()
+
+	return exitSuccess
+}
+
+// simulatedSnapshot returns the template for the snapshots created by a
+// schedule. They look like snapshots of the backup if one is given.
+func simulatedSnapshot(backup *internal.Backup) internal.Snapshot {
+	snapshot := internal.Snapshot{Hostname: "simulated", Paths: []string{"/simulated"}}
+
+	if backup == nil {
+		return snapshot
+	}
+
+	if hostname, err := backup.GetHost(); err == nil {
+		snapshot.Hostname = hostname
+	}
+
+	if len(backup.Data) > 0 {
+		snapshot.Paths = backup.Data
+	}
+
+	snapshot.Tags = []string{internal.BackupTagPrefix + backup.Name}
+
+	return snapshot
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(preflightCmd)
+}
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight",
+	Short: "Check backups before running them",
+	Long: `Run the pre-flight checks of the backups specified on the commandline or all if no backup is specified. ` +
+		`The same checks run at the start of each backup.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForBackupConfigurations(args, runPreflight)
+	},
+}
+
+func runPreflight(backupName string, repositoryName string) (int, error) {
+
+	backup := config.GetBackupByName(backupName)
+
+	if backup == nil {
+		fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
+		os.Exit(exitConfigError)
+	}
+
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		os.Exit(exitConfigError)
+	}
+
+	problems := restic.Preflight(*backup, *repository)
+
+	if len(problems) == 0 {
+		fmt.Printf("%s/%s: ok\n", backupName, repositoryName)
+		return exitSuccess, nil
+	}
+
+	fmt.Printf("%s/%s:\n", backupName, repositoryName)
+	for _, problem := range problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+
+	return exitFailure, nil
+}
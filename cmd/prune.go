@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var pruneForce bool
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().BoolVar(
+		&pruneForce, "force", false,
+		"prune even if the repository has been pruned within its prune min_interval",
+	)
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove unused data from repositories",
+	Long: `Remove data no longer used by any snapshot from the repositories specified on the commandline ` +
+		`or all if no repository is specified. Repositories pruned within their prune min_interval are skipped.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForRepositories(args, runPrune)
+	},
+}
+
+func runPrune(repoName string) (int, error) {
+
+	repo := config.GetRepositoryByName(repoName)
+
+	if repo == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repoName)
+		os.Exit(exitConfigError)
+	}
+
+	if !pruneForce {
+		due, lastPrune, err := restic.IsPruneDue(*repo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get last prune of %s: %s\n", repoName, err.Error())
+		} else if !due {
+			fmt.Printf(
+				"Prune %s skipped, last pruned %s ago\n",
+				repoName, time.Since(lastPrune).Round(time.Minute),
+			)
+			return exitSkipped, nil
+		}
+	}
+
+	err := restic.RunPrune(*repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Prune %s failed to run: %s\n", repoName, err.Error())
+	}
+
+	return exitCodeForError(err), nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var rehostFrom string
+
+func init() {
+	rootCmd.AddCommand(rehostCmd)
+	rehostCmd.Flags().StringVar(
+		&rehostFrom, "from", "",
+		"host name the snapshots have been saved for (required)",
+	)
+	rehostCmd.MarkFlagRequired("from")
+}
+
+var rehostCmd = &cobra.Command{
+	Use:   "rehost",
+	Short: "Move snapshots to the configured host name",
+	Long: `Rewrite the snapshots saved for another host name of the backups specified on the commandline or all if no backup is specified. ` +
+		`The snapshots are moved to the host of the backup or this machine's host name if none is configured. ` +
+		`Needs restic 0.17 or newer.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForBackupConfigurations(args, runRehost)
+	},
+}
+
+func runRehost(backupName string, repositoryName string) (int, error) {
+
+	backup := config.GetBackupByName(backupName)
+
+	if backup == nil {
+		fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
+		os.Exit(exitConfigError)
+	}
+
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		os.Exit(exitConfigError)
+	}
+
+	err := restic.Rehost(*backup, *repository, rehostFrom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rehost %s failed to run: %s\n", backupName, err.Error())
+	}
+
+	return exitCodeForError(err), nil
+}
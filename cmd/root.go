@@ -13,6 +13,8 @@ import (
 )
 
 var cfgFile string
+var dryRun bool
+var verbosity int
 var cfgXdgDefault = ".config/"
 var cfgFileDefault = "rester/config.json"
 
@@ -32,6 +34,14 @@ func init() {
 		&cfgFile, "config", "c", "",
 		fmt.Sprintf("config file (default is $HOME/%s)", cfgXdgDefault+cfgFileDefault),
 	)
+	rootCmd.PersistentFlags().BoolVar(
+		&dryRun, "dry-run", false,
+		"print restic commands and handlers instead of running them",
+	)
+	rootCmd.PersistentFlags().CountVarP(
+		&verbosity, "verbose", "v",
+		"show restic output, repeat to also show each step (-vv)",
+	)
 }
 
 func initConfig() {
@@ -45,7 +55,7 @@ func initConfig() {
 			homedir, err := homedir.Dir()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Failed to get homedir: %s\n", err)
-				os.Exit(1)
+				os.Exit(exitFailure)
 			}
 
 			cfgFile = filepath.Join(homedir, cfgXdgDefault, cfgFileDefault)
@@ -62,22 +72,27 @@ func initConfig() {
 					"Config file permissions allow access for other than user or group. "+
 						"This is insecure. Please restrict file permissions.\n",
 				)
-				os.Exit(1)
+				os.Exit(exitConfigError)
 			}
 		}
 	}
 
 	var err error
 	if config, err = internal.Load(cfgFile); err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to load config: "+err.Error())
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Failed to load config: %s\n", err)
+		os.Exit(exitConfigError)
 	}
 
-	restic = internal.NewRestic(config.ResticExecutable)
+	restic = internal.NewRestic(config.ResticExecutable, internal.ResticOptions{
+		DryRun:         dryRun,
+		Verbosity:      verbosity,
+		Interrupted:    isInterrupted,
+		StateDirectory: config.StateDirectory,
+	})
 
 	if !restic.IsResticAvailable() {
-		fmt.Fprintf(os.Stderr, "Restic command is not available")
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Restic command is not available")
+		os.Exit(exitFailure)
 	}
 }
 
@@ -85,6 +100,27 @@ func runForBackupConfigurations(
 	configurationsToRun []string,
 	handler func(backupName string, repoName string) (returnCode int, err error),
 ) {
+	runForConfigurations(
+		configurationsToRun,
+		func(backup internal.Backup) []string { return backup.Repositories },
+		handler,
+	)
+}
+
+// runForBackupTargets runs like runForBackupConfigurations but includes the
+// repositories the backups are copied to.
+func runForBackupTargets(
+	configurationsToRun []string,
+	handler func(backupName string, repoName string) (returnCode int, err error),
+) {
+	runForConfigurations(configurationsToRun, internal.Backup.GetTargetRepositories, handler)
+}
+
+func runForConfigurations(
+	configurationsToRun []string,
+	repositories func(backup internal.Backup) []string,
+	handler func(backupName string, repoName string) (returnCode int, err error),
+) {
 
 	type Configuration struct {
 		backupName string
@@ -96,7 +132,7 @@ func runForBackupConfigurations(
 	if len(configurationsToRun) == 0 {
 		// if args are empty run all configurations
 		for _, backup := range config.Backups {
-			for _, repo := range backup.Repositories {
+			for _, repo := range repositories(backup) {
 				configsToRun = append(configsToRun, Configuration{backup.Name, repo})
 			}
 		}
@@ -113,10 +149,10 @@ func runForBackupConfigurations(
 
 				if backup == nil {
 					fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
-					os.Exit(1)
+					os.Exit(exitConfigError)
 				}
 
-				for _, repo := range backup.Repositories {
+				for _, repo := range repositories(*backup) {
 					configsToRun = append(configsToRun, Configuration{backupName, repo})
 				}
 
@@ -129,47 +165,91 @@ func runForBackupConfigurations(
 
 				if backup == nil {
 					fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
-					os.Exit(1)
+					os.Exit(exitConfigError)
 				}
 
 				if repo == nil {
 					fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repoName)
-					os.Exit(1)
+					os.Exit(exitConfigError)
 				}
 
-				if !internal.Contains(backup.Repositories, repo.Name) {
+				if !internal.Contains(repositories(*backup), repo.Name) {
 					fmt.Fprintf(os.Stderr, "Repository %s is not a configured for backup %s\n", repo.Name, backup.Name)
-					os.Exit(1)
+					os.Exit(exitConfigError)
 				}
 
 				configsToRun = append(configsToRun, Configuration{backup.Name, repo.Name})
 
 			} else {
 				fmt.Fprintf(os.Stderr, "Configuration %s is invalid\n", configurationName)
-				os.Exit(1)
+				os.Exit(exitConfigError)
 			}
 
 		}
 	}
 
-	finalExitCode := 0
+	var exitCodes []int
 
 	for _, cfg := range configsToRun {
+		if isInterrupted() {
+			exitCodes = append(exitCodes, exitInterrupted)
+			break
+		}
 		exitCode, err := handler(cfg.backupName, cfg.repoName)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
 		}
-		if exitCode > finalExitCode {
-			finalExitCode = exitCode
+		exitCodes = append(exitCodes, exitCode)
+	}
+
+	os.Exit(combineExitCodes(exitCodes))
+}
+
+func runForRepositories(
+	repositoriesToRun []string,
+	handler func(repoName string) (returnCode int, err error),
+) {
+
+	ensureRepositoriesExist(repositoriesToRun)
+
+	if len(repositoriesToRun) == 0 {
+		// if args are empty run all repositories
+		for _, repo := range config.Repositories {
+			repositoriesToRun = append(repositoriesToRun, repo.Name)
+		}
+	}
+
+	var exitCodes []int
+
+	for _, repoName := range repositoriesToRun {
+		if isInterrupted() {
+			exitCodes = append(exitCodes, exitInterrupted)
+			break
 		}
+		exitCode, err := handler(repoName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		}
+		exitCodes = append(exitCodes, exitCode)
 	}
 
-	os.Exit(finalExitCode)
+	os.Exit(combineExitCodes(exitCodes))
+}
+
+func ensureRepositoriesExist(repositories []string) {
+	for _, repoName := range repositories {
+		if config.GetRepositoryByName(repoName) == nil {
+			fmt.Fprintf(os.Stderr, "%s is not a configured repository\n", repoName)
+			os.Exit(exitConfigError)
+		}
+	}
 }
 
 func Execute() {
+	watchInterrupts()
+
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 }
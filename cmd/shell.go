@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/fgma/rester/internal"
 	"github.com/riywo/loginshell"
 	"github.com/spf13/cobra"
 )
@@ -24,17 +25,17 @@ var shellCmd = &cobra.Command{
 
 		if repository == nil {
 			fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
-			os.Exit(1)
+			os.Exit(exitConfigError)
 		}
 
 		shell, err := loginshell.Shell()
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get shell: %s\n", err)
-			os.Exit(1)
+			os.Exit(exitFailure)
 		}
 
-		c := restic.PrepareResticEnvironmentCommand(shell, repository.URL, repository.Password, repository.Environment, 0, 0, []string{})
+		c := restic.PrepareResticEnvironmentCommand(shell, repository.URL, repository.Password, repository.Environment, 0, 0, []string{}, internal.Resources{})
 		c.Stdin = os.Stdin
 		c.Stdout = os.Stdout
 		c.Stderr = os.Stderr
@@ -42,7 +43,7 @@ var shellCmd = &cobra.Command{
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to execute shell: %s\n", err)
-			os.Exit(1)
+			os.Exit(exitFailure)
 		}
 
 	},
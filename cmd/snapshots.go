@@ -17,30 +17,22 @@ var snapshotsCmd = &cobra.Command{
 	Long:  `List snapshots specified for repositories specified on the commandline`,
 	Args:  cobra.ArbitraryArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-
-		if len(args) == 0 {
-			for _, repository := range config.Repositories {
-				printSnapshotsForRepository(repository.Name)
-			}
-		} else {
-			for _, repositoryName := range args {
-				printSnapshotsForRepository(repositoryName)
-			}
-		}
-
+		runForRepositories(args, printSnapshotsForRepository)
 	},
 }
 
-func printSnapshotsForRepository(repositoryName string) {
+func printSnapshotsForRepository(repositoryName string) (int, error) {
 	repository := config.GetRepositoryByName(repositoryName)
 
 	if repository == nil {
 		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
-		os.Exit(1)
+		os.Exit(exitConfigError)
 	}
 
-	if err := restic.PrintSnapshots(*repository); err != nil {
+	err := restic.PrintSnapshots(*repository)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to get snapshots for repository %s\n%s\n", repositoryName, err)
-		os.Exit(1)
 	}
+
+	return exitCodeForError(err), nil
 }
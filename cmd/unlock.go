@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var unlockRemoveAll bool
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+	unlockCmd.Flags().BoolVar(
+		&unlockRemoveAll, "remove-all", false,
+		"remove all locks, even if they are still in use",
+	)
+}
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove stale locks from repositories",
+	Long: `Remove stale locks from the repositories specified on the commandline or all if no repository is specified. ` +
+		`Locks are considered stale by restic if they are older than 30 minutes or the process on this host that created them is gone.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForRepositories(args, unlockRepository)
+	},
+}
+
+func unlockRepository(repositoryName string) (int, error) {
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		os.Exit(exitConfigError)
+	}
+
+	err := restic.Unlock(*repository, unlockRemoveAll)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unlock %s failed to run: %s\n", repositoryName, err.Error())
+	}
+
+	return exitCodeForError(err), nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Restore samples of backups and compare them",
+	Long: `Restore a random sample of files from the latest snapshot of the backups specified on the commandline ` +
+		`or all if no backup is specified and compare them with the live files. ` +
+		`Data read from stdin is restored completely and compared with the checksum recorded at backup time.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runForBackupTargets(args, runVerify)
+	},
+}
+
+func runVerify(backupName string, repositoryName string) (int, error) {
+
+	backup := config.GetBackupByName(backupName)
+
+	if backup == nil {
+		fmt.Fprintf(os.Stderr, "Backup %s is not a configured backup\n", backupName)
+		os.Exit(exitConfigError)
+	}
+
+	repository := config.GetRepositoryByName(repositoryName)
+
+	if repository == nil {
+		fmt.Fprintf(os.Stderr, "Repository %s is not a configured repository\n", repositoryName)
+		os.Exit(exitConfigError)
+	}
+
+	result, err := restic.Verify(*backup, *repository)
+
+	if len(result.Problems) > 0 {
+		fmt.Printf("%s/%s:\n", backupName, repositoryName)
+		for _, problem := range result.Problems {
+			fmt.Printf("  - %s\n", problem)
+		}
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Verify %s/%s failed to run: %s\n", backupName, repositoryName, err.Error())
+	} else if len(backup.GetStdinSources()) > 0 {
+		fmt.Printf("%s/%s: ok (%d stdin sources verified)\n", backupName, repositoryName, result.Sources)
+	} else if result.Changed > 0 {
+		fmt.Printf(
+			"%s/%s: ok (%d files verified, %d changed since the backup)\n",
+			backupName, repositoryName, result.Files, result.Changed,
+		)
+	} else {
+		fmt.Printf("%s/%s: ok (%d files verified)\n", backupName, repositoryName, result.Files)
+	}
+
+	return exitCodeForError(err), nil
+}
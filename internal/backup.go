@@ -0,0 +1,450 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// RunBackup saves the backup to the repository. On success its new snapshots
+// are copied to the given copy targets and verified if configured. The
+// conditions of the backup not depending on the repository are checked by
+// CheckConditions once before.
+func (r Restic) RunBackup(backup Backup, repository Repository, copyTargets []Repository) error {
+
+	repository = backup.overrideRepository(repository)
+	environment := combineMaps(repository.Environment, backup.Environment)
+
+	if reason := r.checkMinInterval(backup, repository); reason != "" {
+		args := newHandlerArgs(&backup, &repository).withError(errors.New(reason))
+		r.runHandler(backup.Handler.Skipped, "skipped", environment, args)
+		return fmt.Errorf("%w: %s", ErrBackupSkipped, reason)
+	}
+
+	if problems := r.Preflight(backup, repository); len(problems) > 0 {
+		err := preflightError(problems)
+		r.runHandlerBackupFailure(backup, repository, environment, err)
+		return err
+	}
+
+	if err := r.IsRepositoryAvailable(repository); err != nil {
+		r.runHandlerBackupFailure(backup, repository, environment, err)
+		return err
+	}
+
+	r.runHandler(backup.Handler.Before, "before", environment, newHandlerArgs(&backup, &repository))
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		r.runHandlerBackupFailure(backup, repository, environment, err)
+		return err
+	}
+
+	var summary *BackupSummary
+	var err error
+
+	if backup.SnapshotProvider.isEnabled() {
+		summary, err = r.runBackupFromSnapshot(backup, repository, environment)
+	} else {
+		summary, err = r.runBackupCommands(backup, repository, environment)
+	}
+
+	args := newHandlerArgs(&backup, &repository).withSummary(summary)
+
+	r.runHandler(backup.Handler.After, "after", environment, args)
+
+	if err != nil {
+		r.runHandlerBackupFailure(backup, repository, environment, err)
+	} else {
+		r.runHandler(backup.Handler.Success, "success", environment, args)
+	}
+
+	if err == nil && len(copyTargets) > 0 {
+		err = r.copySnapshots(backup, repository, copyTargets, summary)
+	}
+
+	if err == nil && backup.Verify.AfterBackup {
+		_, err = r.Verify(backup, repository)
+	}
+
+	return err
+}
+
+// runBackupCommands runs restic backup once for the data of the backup or
+// once for each of its stdin sources.
+func (r Restic) runBackupCommands(
+	backup Backup, repository Repository, environment map[string]string,
+) (*BackupSummary, error) {
+
+	var summary *BackupSummary
+	var err error
+
+	if sources := backup.GetStdinSources(); len(sources) > 0 {
+		// all stdin sources are one logical backup
+		failed := 0
+
+		for i := range sources {
+			if r.isInterrupted() {
+				if err == nil {
+					err = fmt.Errorf("%w before backing up %s", ErrInterrupted, sources[i].Filename)
+				}
+				break
+			}
+
+			sourceSummary, sourceErr := r.runBackupCommand(backup, repository, environment, &sources[i])
+
+			summary = combineBackupSummaries(summary, sourceSummary)
+
+			if sourceErr != nil {
+				failed++
+				if err == nil {
+					err = sourceErr
+				}
+			}
+		}
+
+		if failed > 1 {
+			err = fmt.Errorf("%w (%d of %d stdin sources failed)", err, failed, len(sources))
+		}
+	} else {
+		summary, err = r.runBackupCommand(backup, repository, environment, nil)
+	}
+
+	return summary, err
+}
+
+// runBackupCommand runs restic backup for the data of the backup or for the
+// given stdin source. It returns the summary of the new snapshot.
+func (r Restic) runBackupCommand(
+	backup Backup, repository Repository, environment map[string]string, source *StdinSource,
+) (*BackupSummary, error) {
+
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+	cmd.Args = append(cmd.Args, "backup")
+
+	cmd.Args = append(cmd.Args, backup.Data...)
+
+	for _, exclude := range backup.Exclude {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--exclude=%s", exclude))
+	}
+
+	for _, tag := range backup.Tags {
+		cmd.Args = append(cmd.Args, "--tag", tag)
+	}
+
+	cmd.Args = append(cmd.Args, "--tag", backupTag(backup.Name))
+
+	if backup.Host != "" {
+		cmd.Args = append(cmd.Args, "--host", backup.Host)
+	}
+
+	for _, excludeFile := range backup.ExcludeFile {
+		cmd.Args = append(cmd.Args, "--exclude-file", excludeFile)
+	}
+
+	for _, iexclude := range backup.IExclude {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("--iexclude=%s", iexclude))
+	}
+
+	for _, excludeIfPresent := range backup.ExcludeIfPresent {
+		cmd.Args = append(cmd.Args, "--exclude-if-present", excludeIfPresent)
+	}
+
+	if backup.ExcludeCaches {
+		cmd.Args = append(cmd.Args, "--exclude-caches")
+	}
+
+	if backup.ExcludeLargerThan != "" {
+		cmd.Args = append(cmd.Args, "--exclude-larger-than", backup.ExcludeLargerThan)
+	}
+
+	for _, filesFrom := range backup.FilesFrom {
+		cmd.Args = append(cmd.Args, "--files-from", filesFrom)
+	}
+
+	for _, filesFrom := range backup.FilesFromVerbatim {
+		cmd.Args = append(cmd.Args, "--files-from-verbatim", filesFrom)
+	}
+
+	if backup.OneFileSystem {
+		cmd.Args = append(cmd.Args, "--one-file-system")
+	}
+
+	for _, flag := range backup.CustomFlags {
+		cmd.Args = append(cmd.Args, flag)
+	}
+
+	r.streamOutput(cmd)
+
+	name := fmt.Sprintf("%s/%s", backup.Name, repository.Name)
+	if source != nil {
+		name = fmt.Sprintf("%s/%s (%s)", backup.Name, repository.Name, source.Filename)
+	}
+
+	progress := newBackupProgress(name)
+	cmd.Args = append(cmd.Args, "--json")
+	cmd.Stdout = progress
+	cmd.Stderr = progress
+
+	var cmdStdin *exec.Cmd
+	var pr, pw *os.File
+	var stdinStderr bytes.Buffer
+
+	if source != nil {
+
+		cmd.Args = append(cmd.Args, "--stdin", "--stdin-filename", source.Filename)
+
+		var err error
+
+		cmdStdin, err = prepareShellCommand(
+			source.Command, combineMaps(environment, source.Environment), repository.Resources,
+		)
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to prepare stdin shell command \"%s\": %s\n",
+				source.Command, err,
+			)
+			return nil, err
+		}
+
+		pr, pw, err = os.Pipe()
+
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to create pipe: %s\n",
+				err,
+			)
+			return nil, err
+		}
+
+		cmdStdin.Stdout = pw
+		cmdStdin.Stderr = io.MultiWriter(os.Stderr, &stdinStderr)
+
+		cmd.Stdin = pr
+	}
+
+	if r.dryRun {
+		if cmdStdin != nil {
+			pr.Close()
+			pw.Close()
+			printDryRun(fmt.Sprintf("stdin command for backup [%s]", name), cmdStdin)
+			if source.Validation.Command != "" {
+				if cmdValidation, err := prepareShellCommand(source.Validation.Command, environment, repository.Resources); err == nil {
+					printDryRun(fmt.Sprintf("stdin validation command for backup [%s]", name), cmdValidation)
+				}
+			}
+		}
+		printDryRun(fmt.Sprintf("backup [%s]", name), cmd)
+		return nil, nil
+	}
+
+	var validator *stdinValidator
+
+	if cmdStdin != nil && source.Validation.isEnabled() {
+		r.logStep("validate stdin data for backup [%s]", name)
+
+		var err error
+
+		validator, err = newStdinValidator(source.Validation, environment, repository.Resources)
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to run stdin validation command \"%s\": %s\n",
+				source.Validation.Command, err,
+			)
+			pr.Close()
+			pw.Close()
+			return nil, err
+		}
+
+		cmdStdin.Stdout = io.MultiWriter(pw, validator)
+	}
+
+	var checksum hash.Hash
+
+	if cmdStdin != nil {
+		// recorded so verify can compare the restored data
+		checksum = sha256.New()
+		cmdStdin.Stdout = io.MultiWriter(cmdStdin.Stdout, checksum)
+	}
+
+	if cmdStdin != nil {
+		r.logStep("run stdin command for backup [%s]: %s", name, formatCommandLine(cmdStdin.Args))
+
+		err := cmdStdin.Start()
+
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to run stdin command: %s\n",
+				err,
+			)
+			pr.Close()
+			pw.Close()
+			if validator != nil {
+				validator.Finish()
+			}
+			return nil, err
+		}
+	}
+
+	r.logStep("backup [%s]: %s", name, formatCommandLine(cmd.Args))
+
+	err := cmd.Start()
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to run restic command: %s\n",
+			err,
+		)
+		if cmdStdin != nil {
+			// closing the pipe makes the stdin command fail writing
+			pr.Close()
+			pw.Close()
+			cmdStdin.Wait()
+			if validator != nil {
+				validator.Finish()
+			}
+		}
+		return nil, err
+	}
+
+	// restic may already have read all data and saved a snapshot even if the
+	// stdin command failed, so always wait for both commands
+	var stdinErr error
+
+	if cmdStdin != nil {
+		stdinErr = cmdStdin.Wait()
+		pw.Close()
+
+		if stdinErr != nil {
+			stdinErr = stdinCommandError(stdinErr, stdinStderr.String())
+			fmt.Fprintf(
+				os.Stderr, "Failed to run stdin command for backup [%s]: %s\n",
+				name, stdinErr,
+			)
+		}
+
+		if validator != nil {
+			if validationErr := validator.Finish(); validationErr != nil && stdinErr == nil {
+				stdinErr = validationErr
+				fmt.Fprintf(
+					os.Stderr, "Failed to validate stdin data for backup [%s]: %s\n",
+					name, stdinErr,
+				)
+			}
+		}
+	}
+
+	err = cmd.Wait()
+	progress.Close()
+	if pr != nil {
+		pr.Close()
+	}
+
+	if err != nil {
+		err = resticError(err, progress.ErrorOutput())
+		fmt.Fprintf(
+			os.Stderr, "Failed to wait for restic command: %s\n",
+			err,
+		)
+	}
+
+	summary := progress.Summary()
+
+	if stdinErr != nil {
+		if summary != nil && summary.SnapshotID != "" {
+			r.handleIncompleteSnapshot(backup, repository, summary.SnapshotID)
+			summary = nil
+		}
+		err = stdinErr
+	}
+
+	if err == nil && checksum != nil && summary != nil && summary.SnapshotID != "" {
+		r.recordChecksum(backup, *source, summary.SnapshotID, hex.EncodeToString(checksum.Sum(nil)))
+	}
+
+	return summary, err
+}
+
+// recordChecksum saves the checksum of the data of a stdin source. Failing to
+// do so doesn't fail the backup, the snapshot just can't be compared later.
+func (r Restic) recordChecksum(backup Backup, source StdinSource, snapshotID string, checksum string) {
+	record := checksumRecord{
+		Backup:   backup.Name,
+		Filename: source.Filename,
+		SHA256:   checksum,
+		Time:     time.Now(),
+	}
+
+	if err := saveChecksum(r.stateDirectory, snapshotID, record); err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to record checksum of snapshot %s of backup [%s]: %s\n",
+			shortID(snapshotID), backup.Name, err,
+		)
+	}
+}
+
+// handleIncompleteSnapshot tags or forgets a snapshot whose stdin command
+// failed so it is not considered a valid backup.
+func (r Restic) handleIncompleteSnapshot(backup Backup, repository Repository, snapshotID string) {
+
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+
+	var description string
+
+	if backup.StdinFailure == StdinFailureForget {
+		cmd.Args = append(cmd.Args, "forget", snapshotID)
+		description = fmt.Sprintf("forget incomplete snapshot %s of backup [%s]", shortID(snapshotID), backup.Name)
+	} else {
+		cmd.Args = append(cmd.Args, "tag", "--add", IncompleteTag, snapshotID)
+		description = fmt.Sprintf("tag incomplete snapshot %s of backup [%s]", shortID(snapshotID), backup.Name)
+	}
+
+	if err := r.runRestic(description, cmd); err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to %s: %s\n",
+			description, err,
+		)
+	}
+}
+
+// stdinCommandError adds the last line written to stderr by a failed stdin
+// command to its error.
+func stdinCommandError(err error, stderr string) error {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	lastLine := strings.TrimSpace(lines[len(lines)-1])
+
+	if lastLine == "" {
+		return fmt.Errorf("stdin command failed: %s", err)
+	}
+
+	return fmt.Errorf("stdin command failed: %s: %s", err, lastLine)
+}
+
+// combineBackupSummaries adds up the summaries of multiple snapshots which
+// form one logical backup. The snapshot IDs are joined by commas.
+func combineBackupSummaries(a, b *BackupSummary) *BackupSummary {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	return &BackupSummary{
+		FilesNew:            a.FilesNew + b.FilesNew,
+		FilesChanged:        a.FilesChanged + b.FilesChanged,
+		FilesUnmodified:     a.FilesUnmodified + b.FilesUnmodified,
+		DataAdded:           a.DataAdded + b.DataAdded,
+		TotalFilesProcessed: a.TotalFilesProcessed + b.TotalFilesProcessed,
+		TotalBytesProcessed: a.TotalBytesProcessed + b.TotalBytesProcessed,
+		TotalDuration:       a.TotalDuration + b.TotalDuration,
+		SnapshotID:          a.SnapshotID + "," + b.SnapshotID,
+	}
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdinCommandError(t *testing.T) {
+	err := stdinCommandError(errors.New("exit status 2"), "")
+	assert.Equal(t, "stdin command failed: exit status 2", err.Error())
+
+	err = stdinCommandError(errors.New("exit status 2"), "dumping\nmysqldump: Lost connection\n")
+	assert.Equal(t, "stdin command failed: exit status 2: mysqldump: Lost connection", err.Error())
+}
+
+func TestCombineBackupSummaries(t *testing.T) {
+	assert.Nil(t, combineBackupSummaries(nil, nil))
+
+	a := &BackupSummary{FilesNew: 1, DataAdded: 100, TotalDuration: 1.5, SnapshotID: "aaaa"}
+	b := &BackupSummary{FilesNew: 2, DataAdded: 50, TotalDuration: 2, SnapshotID: "bbbb"}
+
+	assert.Equal(t, a, combineBackupSummaries(nil, a))
+	assert.Equal(t, a, combineBackupSummaries(a, nil))
+
+	summary := combineBackupSummaries(a, b)
+	assert.Equal(t, uint64(3), summary.FilesNew)
+	assert.Equal(t, uint64(150), summary.DataAdded)
+	assert.Equal(t, 3.5, summary.TotalDuration)
+	assert.Equal(t, "aaaa,bbbb", summary.SnapshotID)
+}
+
+func TestLatestBackupTimestampWithStdinSources(t *testing.T) {
+	backup := Backup{
+		StdinSources: []StdinSource{
+			{Command: "pg_dump app", Filename: "app.sql"},
+			{Command: "pg_dump wiki", Filename: "wiki.sql"},
+		},
+	}
+
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	snapshots := []Snapshot{
+		{Time: t1, Hostname: "host", Paths: []string{"/app.sql"}},
+		{Time: t3, Hostname: "host", Paths: []string{"/app.sql"}},
+		{Time: t3, Hostname: "other", Paths: []string{"/wiki.sql"}},
+	}
+
+	assert.Equal(t, time.Time{}, latestBackupTimestamp(backup, snapshots, "host"))
+
+	snapshots = append(snapshots,
+		Snapshot{Time: t2, Hostname: "host", Paths: []string{"/wiki.sql"}},
+		Snapshot{Time: t3, Hostname: "host", Paths: []string{"/wiki.sql"}, Tags: []string{IncompleteTag}},
+	)
+
+	assert.Equal(t, t2, latestBackupTimestamp(backup, snapshots, "host"))
+}
+
+func TestLatestBackupTimestampWithBackupTag(t *testing.T) {
+	backup := Backup{
+		Name: "home",
+		Data: []string{"/home", "/etc"},
+		Tags: []string{"a", "b"},
+	}
+
+	t1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	t3 := t2.Add(time.Hour)
+
+	// untagged snapshots are matched independent of the order of paths and tags
+	snapshots := []Snapshot{
+		{Time: t1, Hostname: "host", Paths: []string{"/etc", "/home"}, Tags: []string{"b", "a"}},
+		{Time: t3, Hostname: "host", Paths: []string{"/etc", "/home"}, Tags: []string{"a", "b", "rester:other"}},
+	}
+	assert.Equal(t, t1, latestBackupTimestamp(backup, snapshots, "host"))
+
+	// tagged snapshots match even after changing data and tags
+	snapshots = append(snapshots,
+		Snapshot{Time: t2, Hostname: "host", Paths: []string{"/home"}, Tags: []string{"c", "rester:home"}},
+		Snapshot{Time: t3, Hostname: "other", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+	)
+	assert.Equal(t, t2, latestBackupTimestamp(backup, snapshots, "host"))
+}
+
+func TestRunBackupInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake restic is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	restic := filepath.Join(dir, "restic")
+	assert.Nil(t, ioutil.WriteFile(restic, []byte("#!/bin/sh\nexit 0\n"), 0755))
+
+	handlerLog := filepath.Join(dir, "handler.log")
+	handler := func(name string) string {
+		return "sh -c 'echo " + name + " >> " + handlerLog + "'"
+	}
+
+	backup := Backup{
+		Name:         "db",
+		StdinSources: []StdinSource{{Command: "echo one", Filename: "one.sql"}},
+		CopyTo:       []string{"cloud"},
+		Verify:       BackupVerify{AfterBackup: true},
+	}
+	backup.Handler.Success = handler("success")
+	backup.Handler.Failure = handler("failure")
+	backup.Handler.CopySuccess = handler("copy_success")
+	backup.Handler.VerifySuccess = handler("verify_success")
+
+	r := NewRestic(restic, ResticOptions{StateDirectory: dir, Interrupted: func() bool { return true }})
+
+	err = r.RunBackup(backup, Repository{Name: "local"}, []Repository{{Name: "cloud"}})
+	assert.True(t, errors.Is(err, ErrInterrupted))
+
+	// neither the success handler nor copy and verify run for a partial backup
+	log, err := ioutil.ReadFile(handlerLog)
+	assert.Nil(t, err)
+	assert.Equal(t, "failure\n", string(log))
+}
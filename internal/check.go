@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dryRunSubset stands in for the subset to read in dry-run mode if it depends
+// on the size of the repository.
+const dryRunSubset = "<subset>"
+
+// CheckStatus is the progress of a repository through its read data subsets.
+// Subsets are read in order so all data has been read once Subset reaches
+// Subsets. The cycle then starts over with the first subset.
+type CheckStatus struct {
+	Subset       int       `json:"subset"`
+	Subsets      int       `json:"subsets"`
+	CycleStart   time.Time `json:"cycle_start"`
+	LastCheck    time.Time `json:"last_check"`
+	LastFullRead time.Time `json:"last_full_read"`
+}
+
+// Coverage returns the percentage of the subsets read in the current cycle.
+func (s CheckStatus) Coverage() float64 {
+	if s.Subsets == 0 {
+		return 0
+	}
+	return 100.0 * float64(s.Subset) / float64(s.Subsets)
+}
+
+func (s CheckStatus) isCycleComplete() bool {
+	return s.Subset >= s.Subsets
+}
+
+// subsetsForPercentage returns the number of subsets each holding at most the
+// given percentage of the data.
+func subsetsForPercentage(percentage uint) int {
+	return int(math.Ceil(100.0 / float64(percentage)))
+}
+
+// subsetsForSize returns the number of subsets each holding about the given
+// amount of the data of a repository of the given total size.
+func subsetsForSize(size uint64, totalSize uint64) int {
+	subsets := int(math.Ceil(float64(totalSize) / float64(size)))
+	if subsets < 1 {
+		return 1
+	}
+	return subsets
+}
+
+// nextSubset returns the subset to read after the given status. A new cycle
+// starts with the first subset once all subsets have been read or if their
+// number has changed.
+func nextSubset(status CheckStatus, subsets int) int {
+	if status.Subsets != subsets || status.isCycleComplete() {
+		return 1
+	}
+	return status.Subset + 1
+}
+
+// parseSize parses a size like restic's e.g. "2G" into bytes.
+func parseSize(size string) (uint64, error) {
+	if !sizePattern.MatchString(size) {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+
+	factor := uint64(1)
+	switch strings.ToUpper(size[len(size)-1:]) {
+	case "K":
+		factor = 1 << 10
+	case "M":
+		factor = 1 << 20
+	case "G":
+		factor = 1 << 30
+	case "T":
+		factor = 1 << 40
+	}
+
+	if factor > 1 {
+		size = size[:len(size)-1]
+	}
+
+	value, err := strconv.ParseUint(size, 10, 64)
+	if err != nil || value == 0 {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+
+	return value * factor, nil
+}
+
+// GetCheckStatus returns the progress of the repository through its read
+// data subsets as recorded by previous checks.
+func (r Restic) GetCheckStatus(repository Repository) (CheckStatus, error) {
+	state := make(map[string]CheckStatus)
+
+	if err := readStateFile(r.stateDirectory, checkFile, &state); err != nil {
+		return CheckStatus{}, err
+	}
+
+	return state[repository.Name], nil
+}
+
+func (r Restic) recordCheck(repository Repository, status CheckStatus) error {
+	state := make(map[string]CheckStatus)
+
+	if err := readStateFile(r.stateDirectory, checkFile, &state); err != nil {
+		return err
+	}
+
+	state[repository.Name] = status
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(r.stateDirectory, checkFile, data)
+}
+
+// RunCheck checks the repository. With read_data_percentage or read_data_size
+// a subset of the data is read. The subsets are read in order across runs so
+// all data has been read after as many checks as there are subsets.
+func (r Restic) RunCheck(repository Repository) error {
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		r.runHandlerCheckFailure(repository, err)
+		return err
+	}
+
+	status, err := r.GetCheckStatus(repository)
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to get check status of repository [%s], starting with the first subset: %s\n",
+			repository.Name, err,
+		)
+		status = CheckStatus{}
+	}
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "check")
+
+	next := CheckStatus{CycleStart: status.CycleStart, LastFullRead: status.LastFullRead}
+
+	if repository.Check.ReadDataPercentage >= 100 {
+		cmd.Args = append(cmd.Args, "--read-data")
+		next.Subset, next.Subsets = 1, 1
+	} else if repository.Check.ReadDataPercentage > 0 || repository.Check.ReadDataSize != "" {
+		next.Subsets, err = r.readDataSubsets(repository, status)
+		if err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to check repository [%s]: %s\n",
+				repository.Name,
+				err,
+			)
+			r.runHandlerCheckFailure(repository, err)
+			return err
+		}
+
+		if next.Subsets == 0 {
+			cmd.Args = append(cmd.Args, "--read-data-subset="+dryRunSubset)
+		} else {
+			next.Subset = nextSubset(status, next.Subsets)
+			cmd.Args = append(cmd.Args, fmt.Sprintf("--read-data-subset=%d/%d", next.Subset, next.Subsets))
+		}
+	}
+
+	r.streamOutput(cmd)
+
+	err = r.runRestic(fmt.Sprintf("check repository [%s]", repository.Name), cmd)
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to check repository [%s]: %s\n",
+			repository.Name,
+			err,
+		)
+		r.runHandlerCheckFailure(repository, err)
+		return err
+	}
+
+	if !r.dryRun {
+		now := time.Now()
+		next.LastCheck = now
+
+		if next.Subset == 1 {
+			next.CycleStart = now
+		}
+
+		if next.Subsets > 0 && next.isCycleComplete() {
+			next.LastFullRead = now
+		}
+
+		if err := r.recordCheck(repository, next); err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to record check of repository [%s]: %s\n",
+				repository.Name,
+				err,
+			)
+		}
+	}
+
+	r.runHandler(repository.Handler.CheckSuccess, "check_success", repository.Environment, newHandlerArgs(nil, &repository))
+
+	return nil
+}
+
+// readDataSubsets returns the number of subsets to split the data of the
+// repository into. For read_data_size it is determined from the size of the
+// repository when a cycle starts and kept until all subsets have been read.
+// In dry-run mode the size is unknown and 0 is returned.
+func (r Restic) readDataSubsets(repository Repository, status CheckStatus) (int, error) {
+
+	if repository.Check.ReadDataPercentage > 0 {
+		return subsetsForPercentage(repository.Check.ReadDataPercentage), nil
+	}
+
+	if status.Subsets > 0 && !status.isCycleComplete() {
+		return status.Subsets, nil
+	}
+
+	size, err := parseSize(repository.Check.ReadDataSize)
+	if err != nil {
+		return 0, err
+	}
+
+	totalSize, err := r.repositorySize(repository)
+	if err != nil || r.dryRun {
+		return 0, err
+	}
+
+	return subsetsForSize(size, totalSize), nil
+}
+
+// repositorySize returns the size of the data stored in the repository.
+func (r Restic) repositorySize(repository Repository) (uint64, error) {
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "stats", "--mode", "raw-data", "--json")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	err := r.runRestic(fmt.Sprintf("get size of repository [%s]", repository.Name), cmd)
+	if err != nil || r.dryRun {
+		return 0, err
+	}
+
+	var stats struct {
+		TotalSize uint64 `json:"total_size"`
+	}
+
+	if err := json.Unmarshal(output.Bytes(), &stats); err != nil {
+		return 0, fmt.Errorf("failed to parse output of stats: %s", err)
+	}
+
+	return stats.TotalSize, nil
+}
+
+func (r Restic) runHandlerCheckFailure(repository Repository, err error) {
+	r.runHandler(repository.Handler.CheckFailure, "check_failure", repository.Environment, newHandlerArgs(nil, &repository).withError(err))
+}
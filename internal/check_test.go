@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for size, expected := range map[string]uint64{
+		"512": 512,
+		"4k":  4 << 10,
+		"10M": 10 << 20,
+		"2G":  2 << 30,
+		"1T":  1 << 40,
+	} {
+		actual, err := parseSize(size)
+		assert.NoError(t, err, size)
+		assert.Equal(t, expected, actual, size)
+	}
+
+	for _, size := range []string{"", "0", "0G", "2 G", "2GB", "G"} {
+		_, err := parseSize(size)
+		assert.Error(t, err, size)
+	}
+}
+
+func TestReadDataSubsets(t *testing.T) {
+	assert.Equal(t, 20, subsetsForPercentage(5))
+	assert.Equal(t, 3, subsetsForPercentage(43))
+	assert.Equal(t, 1, subsetsForPercentage(100))
+
+	assert.Equal(t, 3, subsetsForSize(2<<30, 5<<30))
+	assert.Equal(t, 1, subsetsForSize(2<<30, 1<<30))
+	assert.Equal(t, 1, subsetsForSize(2<<30, 0))
+}
+
+func TestNextSubset(t *testing.T) {
+	assert.Equal(t, 1, nextSubset(CheckStatus{}, 4))
+	assert.Equal(t, 2, nextSubset(CheckStatus{Subset: 1, Subsets: 4}, 4))
+	assert.Equal(t, 4, nextSubset(CheckStatus{Subset: 3, Subsets: 4}, 4))
+
+	// a completed cycle starts over
+	assert.Equal(t, 1, nextSubset(CheckStatus{Subset: 4, Subsets: 4}, 4))
+
+	// a changed number of subsets starts a new cycle
+	assert.Equal(t, 1, nextSubset(CheckStatus{Subset: 2, Subsets: 4}, 20))
+}
+
+func TestCheckStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester-state-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := NewRestic("restic", ResticOptions{StateDirectory: dir})
+
+	status, err := r.GetCheckStatus(Repository{Name: "local"})
+	assert.NoError(t, err)
+	assert.Equal(t, CheckStatus{}, status)
+	assert.Equal(t, 0.0, status.Coverage())
+
+	now := time.Now().UTC().Round(time.Second)
+	assert.NoError(t, r.recordCheck(Repository{Name: "local"}, CheckStatus{
+		Subset: 5, Subsets: 20, CycleStart: now.Add(-96 * time.Hour), LastCheck: now,
+	}))
+	assert.NoError(t, r.recordCheck(Repository{Name: "cloud"}, CheckStatus{Subset: 1, Subsets: 1, LastFullRead: now}))
+
+	status, err = r.GetCheckStatus(Repository{Name: "local"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, status.Subset)
+	assert.Equal(t, 25.0, status.Coverage())
+	assert.False(t, status.isCycleComplete())
+	assert.True(t, now.Equal(status.LastCheck))
+
+	status, err = r.GetCheckStatus(Repository{Name: "cloud"})
+	assert.NoError(t, err)
+	assert.True(t, status.isCycleComplete())
+	assert.True(t, now.Equal(status.LastFullRead))
+}
@@ -0,0 +1,190 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const redacted = "<redacted>"
+
+// ErrRepositoryLocked is returned if restic failed because the repository is
+// locked by another process.
+var ErrRepositoryLocked = errors.New("repository is locked")
+
+// ErrIncompleteSnapshot is returned if restic created a snapshot but some
+// source files could not be read.
+var ErrIncompleteSnapshot = errors.New("snapshot is incomplete")
+
+// ErrInterrupted is returned if rester got interrupted before all commands of
+// a run were done.
+var ErrInterrupted = errors.New("interrupted")
+
+var secretEnvironmentKeys = []string{
+	"PASSWORD", "PASSWD", "_PWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL",
+}
+
+var urlCredentials = regexp.MustCompile(`://([^:/@]+):([^@/]+)@`)
+
+// run executes the given command. In dry-run mode the command is only printed.
+func (r Restic) run(description string, cmd *exec.Cmd) error {
+	if r.dryRun {
+		printDryRun(description, cmd)
+		return nil
+	}
+	r.logStep("%s: %s", description, formatCommandLine(cmd.Args))
+	return cmd.Run()
+}
+
+// runRestic runs a restic command like run but inspects the result to return
+// ErrRepositoryLocked or ErrIncompleteSnapshot where appropriate.
+func (r Restic) runRestic(description string, cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	if cmd.Stderr == nil {
+		cmd.Stderr = &stderr
+	} else {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, &stderr)
+	}
+
+	return resticError(r.run(description, cmd), stderr.String())
+}
+
+// resticError maps well known restic failures given by its exit code or its
+// output on stderr to ErrRepositoryLocked or ErrIncompleteSnapshot.
+func resticError(err error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if stderr == "" {
+			stderr = string(exitErr.Stderr)
+		}
+		switch exitErr.ExitCode() {
+		case 3:
+			return fmt.Errorf("%w: %s", ErrIncompleteSnapshot, err)
+		case 11:
+			return fmt.Errorf("%w: %s", ErrRepositoryLocked, err)
+		}
+	}
+
+	if strings.Contains(stderr, "repository is already locked") {
+		return fmt.Errorf("%w: %s", ErrRepositoryLocked, err)
+	}
+
+	return err
+}
+
+// logStep prints what rester is doing if running with a verbosity of 2 or above.
+func (r Restic) logStep(format string, a ...interface{}) {
+	if r.verbosity < 2 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "rester: "+format+"\n", a...)
+}
+
+// streamOutput makes restic verbose and forwards its output if running verbose.
+func (r Restic) streamOutput(cmd *exec.Cmd) {
+	if r.verbosity < 1 {
+		return
+	}
+	cmd.Args = append(cmd.Args, fmt.Sprintf("--verbose=%d", r.verbosity))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+}
+
+func printDryRun(description string, cmd *exec.Cmd) {
+	directory := cmd.Dir
+	if directory == "" {
+		directory, _ = os.Getwd()
+	}
+
+	fmt.Printf("[dry-run] %s\n", description)
+	fmt.Printf("  command:     %s\n", formatCommandLine(cmd.Args))
+	fmt.Printf("  directory:   %s\n", directory)
+
+	environment := additionalEnvironment(cmd.Env)
+	if len(environment) == 0 {
+		fmt.Printf("  environment: -\n")
+	}
+	for i, v := range environment {
+		if i == 0 {
+			fmt.Printf("  environment: %s\n", v)
+		} else {
+			fmt.Printf("               %s\n", v)
+		}
+	}
+}
+
+// additionalEnvironment returns the sorted and redacted entries of env which
+// are not inherited from the environment of rester itself.
+func additionalEnvironment(env []string) []string {
+	inherited := make(map[string]bool)
+	for _, v := range os.Environ() {
+		inherited[v] = true
+	}
+
+	var result []string
+	for _, v := range env {
+		if !inherited[v] {
+			result = append(result, redactEnvironmentVariable(v))
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+func redactEnvironmentVariable(variable string) string {
+	split := strings.SplitN(variable, "=", 2)
+	if len(split) != 2 {
+		return variable
+	}
+
+	key, value := split[0], split[1]
+
+	if isSecretEnvironmentKey(key) {
+		return key + "=" + redacted
+	}
+
+	return key + "=" + redactURL(value)
+}
+
+func isSecretEnvironmentKey(key string) bool {
+	upperKey := strings.ToUpper(key)
+	for _, s := range secretEnvironmentKeys {
+		if strings.Contains(upperKey, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactURL(url string) string {
+	return urlCredentials.ReplaceAllString(url, "://$1:"+redacted+"@")
+}
+
+func formatCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArgument(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArgument(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if !strings.ContainsAny(arg, " \t\n'\"\\$`|&;<>()*?[]{}~!#") {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
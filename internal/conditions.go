@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+// ErrBackupSkipped is returned if a backup did not run because one of its
+// conditions is not met.
+var ErrBackupSkipped = errors.New("skipped")
+
+// CheckConditions checks the conditions of the backup which don't depend on
+// the repository and delays the backup by its random delay if they are met.
+// It is called once per backup before running it for each of its
+// repositories. It returns ErrBackupSkipped if the backup should be skipped.
+func (r Restic) CheckConditions(backup Backup) error {
+
+	if reason := r.checkConditions(backup, backup.Environment); reason != "" {
+		args := newHandlerArgs(&backup, nil).withError(errors.New(reason))
+		r.runHandler(backup.Handler.Skipped, "skipped", backup.Environment, args)
+		return fmt.Errorf("%w: %s", ErrBackupSkipped, reason)
+	}
+
+	r.randomDelay(backup, backup.Conditions.RandomDelay.Duration)
+
+	if r.isInterrupted() {
+		return fmt.Errorf("%w: interrupted", ErrBackupSkipped)
+	}
+
+	return nil
+}
+
+// checkConditions returns the reason why the backup should be skipped or an
+// empty string if all conditions not depending on the repository are met.
+func (r Restic) checkConditions(backup Backup, environment map[string]string) string {
+
+	conditions := backup.Conditions
+
+	for _, path := range conditions.RequirePath {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Sprintf("required path %s is not present", path)
+		}
+	}
+
+	if conditions.NotOnBattery && onBattery(powerSupplyDir) {
+		return "running on battery"
+	}
+
+	if conditions.RequireCommand != "" {
+		cmd, err := prepareShellCommand(conditions.RequireCommand, environment, Resources{})
+		if err != nil {
+			return fmt.Sprintf("failed to prepare required command: %s", err)
+		}
+
+		err = r.run(fmt.Sprintf("required command for backup [%s]", backup.Name), cmd)
+		if err != nil {
+			return fmt.Sprintf("required command failed: %s", err)
+		}
+	}
+
+	return ""
+}
+
+// checkMinInterval returns the reason why the backup to the repository should
+// be skipped as its last backup is younger than min_interval or an empty
+// string otherwise.
+func (r Restic) checkMinInterval(backup Backup, repository Repository) string {
+
+	minInterval := backup.Conditions.MinInterval.Duration
+	if minInterval <= 0 {
+		return ""
+	}
+
+	// a backup which can't be checked is not skipped, it fails later on
+	lastBackupTimestamp, err := r.GetLastBackupTimestamp(backup, repository)
+	if err != nil || (lastBackupTimestamp == time.Time{}) {
+		return ""
+	}
+
+	age := time.Since(lastBackupTimestamp)
+	if age < minInterval {
+		return fmt.Sprintf("last backup is only %s old, min_interval is %s", age.Round(time.Second), minInterval)
+	}
+
+	return ""
+}
+
+// randomDelay waits for a random duration up to max. It returns early if
+// rester gets interrupted.
+func (r Restic) randomDelay(backup Backup, max time.Duration) {
+
+	if max <= 0 {
+		return
+	}
+
+	delay := time.Duration(rand.Int63n(int64(max)))
+
+	if r.dryRun {
+		fmt.Printf("[dry-run] random delay of backup [%s] up to %s\n", backup.Name, max)
+		return
+	}
+
+	r.logStep("delay backup [%s] by %s", backup.Name, delay.Round(time.Second))
+
+	deadline := time.Now().Add(delay)
+	for time.Now().Before(deadline) && !r.isInterrupted() {
+		time.Sleep(minDuration(time.Until(deadline), time.Second))
+	}
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// onBattery reports whether the machine runs on battery according to the
+// power supplies found in dir. Without any information it is assumed that the
+// machine does not run on battery.
+func onBattery(dir string) bool {
+
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	supplies, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+
+	hasMains, mainsOnline, discharging := false, false, false
+
+	for _, supply := range supplies {
+		path := filepath.Join(dir, supply.Name())
+
+		switch readPowerSupplyValue(path, "type") {
+		case "Mains":
+			hasMains = true
+			if readPowerSupplyValue(path, "online") == "1" {
+				mainsOnline = true
+			}
+		case "Battery":
+			if readPowerSupplyValue(path, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+
+	if hasMains {
+		return !mainsOnline
+	}
+
+	return discharging
+}
+
+func readPowerSupplyValue(path string, name string) string {
+	value, err := ioutil.ReadFile(filepath.Join(path, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePowerSupply(t *testing.T, dir string, name string, values map[string]string) {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, os.MkdirAll(path, 0755))
+	for k, v := range values {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(path, k), []byte(v+"\n"), 0644))
+	}
+}
+
+func TestOnBattery(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("power supplies are only read on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.False(t, onBattery(filepath.Join(dir, "missing")))
+	assert.False(t, onBattery(dir))
+
+	writePowerSupply(t, dir, "BAT0", map[string]string{"type": "Battery", "status": "Discharging"})
+	assert.True(t, onBattery(dir))
+
+	writePowerSupply(t, dir, "AC", map[string]string{"type": "Mains", "online": "1"})
+	assert.False(t, onBattery(dir))
+
+	writePowerSupply(t, dir, "AC", map[string]string{"type": "Mains", "online": "0"})
+	assert.True(t, onBattery(dir))
+}
+
+func TestCheckConditions(t *testing.T) {
+	r := NewRestic("restic", ResticOptions{})
+
+	backup := Backup{Name: "home"}
+	assert.Equal(t, "", r.checkConditions(backup, nil))
+
+	backup.Conditions.RequirePath = []string{os.TempDir()}
+	assert.Equal(t, "", r.checkConditions(backup, nil))
+
+	backup.Conditions.RequireCommand = "sh -c 'exit 1'"
+	assert.Equal(t, "required command failed: exit status 1", r.checkConditions(backup, nil))
+
+	backup.Conditions.RequirePath = []string{"/rester/missing"}
+	assert.Equal(t, "required path /rester/missing is not present", r.checkConditions(backup, nil))
+}
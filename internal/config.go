@@ -6,12 +6,21 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"time"
 
 	jsonutil "github.com/vrischmann/jsonutil"
 )
 
+// sizes as accepted by restic e.g. "500k" or "2G"
+var sizePattern = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+// unused space tolerated by prune e.g. "5%", "2G" or "unlimited"
+var maxUnusedPattern = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?%|[0-9]+[kKmMgGtT]?|unlimited)$`)
+
 type Policy struct {
 	KeepLast    uint     `json:"keep_last,omitempty"`
 	KeepHourly  uint     `json:"keep_hourly,omitempty"`
@@ -21,10 +30,29 @@ type Policy struct {
 	KeepYearly  uint     `json:"keep_yearly,omitempty"`
 	KeepWithin  string   `json:"keep_within,omitempty"`
 	KeepTags    []string `json:"keep_tags,omitempty"`
+	GroupBy     string   `json:"group_by,omitempty"`
+	ScopeBy     string   `json:"scope_by,omitempty"`
 }
 
 type Check struct {
-	ReadDataPercentage uint `json:"read_data_percentage,omitempty"`
+	ReadDataPercentage uint   `json:"read_data_percentage,omitempty"`
+	ReadDataSize       string `json:"read_data_size,omitempty"`
+}
+
+const (
+	LockStrategyNone      = "none"
+	LockStrategyStaleOnly = "stale-only"
+	LockStrategyWait      = "wait"
+)
+
+// restic itself considers locks older than 30 minutes stale
+const minLockStaleAfter = 30 * time.Minute
+const defaultLockWaitTimeout = 30 * time.Minute
+
+type Lock struct {
+	Strategy    string            `json:"strategy,omitempty"`
+	StaleAfter  jsonutil.Duration `json:"stale_after,omitempty"`
+	WaitTimeout jsonutil.Duration `json:"wait_timeout,omitempty"`
 }
 
 type RepositoryHandler struct {
@@ -32,13 +60,73 @@ type RepositoryHandler struct {
 	ForgetFailure string `json:"forget_failure,omitempty"`
 	CheckSuccess  string `json:"check_success,omitempty"`
 	CheckFailure  string `json:"check_failure,omitempty"`
+	PruneSuccess  string `json:"prune_success,omitempty"`
+	PruneFailure  string `json:"prune_failure,omitempty"`
+}
+
+// Prune configures how unused data is removed from the repository.
+// MinInterval is a schedule hint: prune skips the repository if it has been
+// pruned within the interval.
+type Prune struct {
+	MaxUnused           string            `json:"max_unused,omitempty"`
+	MaxRepackSize       string            `json:"max_repack_size,omitempty"`
+	RepackCacheableOnly bool              `json:"repack_cacheable_only,omitempty"`
+	MinInterval         jsonutil.Duration `json:"min_interval,omitempty"`
+}
+
+const (
+	IONiceClassRealtime   = "realtime"
+	IONiceClassBestEffort = "best-effort"
+	IONiceClassIdle       = "idle"
+)
+
+// Resources lowers the priority and limits the resources of restic and the
+// stdin commands.
+type Resources struct {
+	Nice            int    `json:"nice,omitempty"`
+	IONiceClass     string `json:"ionice_class,omitempty"`
+	IONiceLevel     int    `json:"ionice_level,omitempty"`
+	GOMAXPROCS      int    `json:"gomaxprocs,omitempty"`
+	ReadConcurrency int    `json:"read_concurrency,omitempty"`
+}
+
+// override returns r with all settings given in o replaced.
+func (r Resources) override(o Resources) Resources {
+	if o.Nice != 0 {
+		r.Nice = o.Nice
+	}
+	if o.IONiceClass != "" {
+		r.IONiceClass = o.IONiceClass
+		r.IONiceLevel = o.IONiceLevel
+	}
+	if o.GOMAXPROCS != 0 {
+		r.GOMAXPROCS = o.GOMAXPROCS
+	}
+	if o.ReadConcurrency != 0 {
+		r.ReadConcurrency = o.ReadConcurrency
+	}
+	return r
+}
+
+// ForgetGuard protects against removing more snapshots than expected.
+// Snapshots younger than MinAge are never removed whatever the policy says.
+type ForgetGuard struct {
+	MaxRemovePercentage uint              `json:"max_remove_percentage,omitempty"`
+	MaxRemoveCount      uint              `json:"max_remove_count,omitempty"`
+	MinAge              jsonutil.Duration `json:"min_age,omitempty"`
 }
 
 type repositoryDefaultable struct {
 	Policy        Policy            `json:"policy,omitempty"`
+	ForgetGuard   ForgetGuard       `json:"forget_guard,omitempty"`
 	Handler       RepositoryHandler `json:"handler,omitempty"`
+	Lock          Lock              `json:"lock,omitempty"`
 	LimitDownload int               `json:"limit_download,omitempty"`
 	LimitUpload   int               `json:"limit_upload,omitempty"`
+	Resources     Resources         `json:"resources,omitempty"`
+	Prune         Prune             `json:"prune,omitempty"`
+
+	CacheMinFreeSpace string `json:"cache_min_free_space,omitempty"`
 }
 
 type Repository struct {
@@ -51,6 +139,11 @@ type Repository struct {
 	repositoryDefaultable
 }
 
+const (
+	StdinFailureTag    = "tag"
+	StdinFailureForget = "forget"
+)
+
 type BackupHandler struct {
 	Before   string `json:"before,omitempty"`
 	After    string `json:"after,omitempty"`
@@ -58,6 +151,98 @@ type BackupHandler struct {
 	Failure  string `json:"failure,omitempty"`
 	AgeWarn  string `json:"age_warn,omitempty"`
 	AgeError string `json:"age_error,omitempty"`
+	Skipped  string `json:"skipped,omitempty"`
+
+	CopySuccess string `json:"copy_success,omitempty"`
+	CopyFailure string `json:"copy_failure,omitempty"`
+
+	VerifySuccess string `json:"verify_success,omitempty"`
+	VerifyFailure string `json:"verify_failure,omitempty"`
+}
+
+type StdinValidation struct {
+	MinBytes       uint64 `json:"min_bytes,omitempty"`
+	TrailingMarker string `json:"trailing_marker,omitempty"`
+	Command        string `json:"command,omitempty"`
+}
+
+func (v StdinValidation) isEnabled() bool {
+	return v.MinBytes > 0 || v.TrailingMarker != "" || v.Command != ""
+}
+
+// StdinSource is one command whose output is saved as a file named filename.
+// Without a validation of its own the backup's stdin_validation is used.
+type StdinSource struct {
+	Command     string            `json:"command,omitempty"`
+	Filename    string            `json:"filename,omitempty"`
+	Validation  StdinValidation   `json:"validation,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+const (
+	DatabasePostgres = "postgres"
+	DatabaseMySQL    = "mysql"
+	DatabaseSQLite   = "sqlite"
+)
+
+// Database is a database dumped by a builtin dump command as a stdin source.
+type Database struct {
+	Type       string          `json:"type,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	Host       string          `json:"host,omitempty"`
+	Port       int             `json:"port,omitempty"`
+	User       string          `json:"user,omitempty"`
+	Password   string          `json:"password,omitempty"`
+	Options    []string        `json:"options,omitempty"`
+	Filename   string          `json:"filename,omitempty"`
+	Validation StdinValidation `json:"validation,omitempty"`
+}
+
+const (
+	SnapshotProviderCustom = "custom"
+	SnapshotProviderBtrfs  = "btrfs"
+	SnapshotProviderZFS    = "zfs"
+	SnapshotProviderLVM    = "lvm"
+)
+
+const defaultLVMSnapshotSize = "1G"
+
+// SnapshotProvider creates a filesystem snapshot of path which is available
+// at mount_path while the backup is running.
+type SnapshotProvider struct {
+	Type      string `json:"type,omitempty"`
+	Path      string `json:"path,omitempty"`
+	MountPath string `json:"mount_path,omitempty"`
+	Volume    string `json:"volume,omitempty"`
+	Size      string `json:"size,omitempty"`
+	Create    string `json:"create,omitempty"`
+	Destroy   string `json:"destroy,omitempty"`
+}
+
+func (p SnapshotProvider) isEnabled() bool {
+	return p.Type != ""
+}
+
+// BackupConditions are checked before running a backup. If any of them is
+// not met the backup is skipped.
+type BackupConditions struct {
+	MinInterval    jsonutil.Duration `json:"min_interval,omitempty"`
+	RequirePath    []string          `json:"require_path,omitempty"`
+	RequireCommand string            `json:"require_command,omitempty"`
+	NotOnBattery   bool              `json:"not_on_battery,omitempty"`
+	RandomDelay    jsonutil.Duration `json:"random_delay,omitempty"`
+}
+
+// defaultVerifyFiles is the number of files restored by verify if neither a
+// number of files nor a percentage is configured.
+const defaultVerifyFiles = 10
+
+// BackupVerify configures the restore drills run by verify.
+type BackupVerify struct {
+	Files       int  `json:"files,omitempty"`
+	Percentage  int  `json:"percentage,omitempty"`
+	AfterBackup bool `json:"after_backup,omitempty"`
 }
 
 type BackupAge struct {
@@ -66,8 +251,17 @@ type BackupAge struct {
 }
 
 type backupDefaultable struct {
-	Handler BackupHandler `json:"handler,omitempty"`
-	Age     BackupAge     `json:"age,omitempty"`
+	Handler           BackupHandler `json:"handler,omitempty"`
+	Age               BackupAge     `json:"age,omitempty"`
+	StdinFailure      string        `json:"stdin_failure,omitempty"`
+	ExcludeFile       []string      `json:"exclude_file,omitempty"`
+	IExclude          []string      `json:"iexclude,omitempty"`
+	ExcludeIfPresent  []string      `json:"exclude_if_present,omitempty"`
+	ExcludeCaches     bool          `json:"exclude_caches,omitempty"`
+	ExcludeLargerThan string        `json:"exclude_larger_than,omitempty"`
+	FilesFrom         []string      `json:"files_from,omitempty"`
+	FilesFromVerbatim []string      `json:"files_from_verbatim,omitempty"`
+	Host              string        `json:"host,omitempty"`
 }
 
 type Backup struct {
@@ -76,14 +270,114 @@ type Backup struct {
 	Data             []string          `json:"data,omitempty"`
 	DataStdinCommand string            `json:"data_stdin_command,omitempty"`
 	StdinFilename    string            `json:"stdin_filename,omitempty"`
+	StdinValidation  StdinValidation   `json:"stdin_validation,omitempty"`
+	StdinSources     []StdinSource     `json:"stdin_sources,omitempty"`
+	Databases        []Database        `json:"databases,omitempty"`
 	Exclude          []string          `json:"exclude,omitempty"`
 	OneFileSystem    bool              `json:"one_file_system,omitempty"`
 	Tags             []string          `json:"tags,omitempty"`
 	Environment      map[string]string `json:"environment,omitempty"`
 	CustomFlags      []string          `json:"custom_flags,omitempty"`
+	SnapshotProvider SnapshotProvider  `json:"snapshot_provider,omitempty"`
+	LimitDownload    int               `json:"limit_download,omitempty"`
+	LimitUpload      int               `json:"limit_upload,omitempty"`
+	Resources        Resources         `json:"resources,omitempty"`
+	Conditions       BackupConditions  `json:"conditions,omitempty"`
+	CopyTo           []string          `json:"copy_to,omitempty"`
+	Verify           BackupVerify      `json:"verify,omitempty"`
+	Policy           Policy            `json:"policy,omitempty"`
 	backupDefaultable
 }
 
+// GetStdinSources returns all stdin sources of the backup including the one
+// given by data_stdin_command and stdin_filename and those dumping databases.
+func (b Backup) GetStdinSources() []StdinSource {
+	var sources []StdinSource
+
+	if b.DataStdinCommand != "" {
+		sources = append(sources, StdinSource{
+			Command:  b.DataStdinCommand,
+			Filename: b.StdinFilename,
+		})
+	}
+
+	sources = append(sources, b.StdinSources...)
+
+	for _, database := range b.Databases {
+		sources = append(sources, database.stdinSource())
+	}
+
+	for i := range sources {
+		if !sources[i].Validation.isEnabled() {
+			sources[i].Validation = b.StdinValidation
+		}
+	}
+
+	return sources
+}
+
+// overrideRepository returns the repository with the settings the backup
+// overrides when backing up to it.
+func (b Backup) overrideRepository(repository Repository) Repository {
+	if b.LimitDownload > 0 {
+		repository.LimitDownload = b.LimitDownload
+	}
+	if b.LimitUpload > 0 {
+		repository.LimitUpload = b.LimitUpload
+	}
+	repository.Resources = repository.Resources.override(b.Resources)
+	return repository
+}
+
+// GetTargetRepositories returns the names of all repositories holding
+// snapshots of the backup: those it is saved to followed by those the
+// snapshots are copied to.
+func (b Backup) GetTargetRepositories() []string {
+	var repositories []string
+	repositories = append(repositories, b.Repositories...)
+	return append(repositories, b.CopyTo...)
+}
+
+// GetHost returns the host name the snapshots of the backup are saved for.
+// Without a configured host this is the host name of this machine.
+func (b Backup) GetHost() (string, error) {
+	if b.Host != "" {
+		return b.Host, nil
+	}
+	return os.Hostname()
+}
+
+// GetPolicy returns the policy for the snapshots of the backup in the
+// repository. Without a policy of its own the policy of the repository applies.
+func (b Backup) GetPolicy(repository Repository) Policy {
+	if b.Policy.isEmpty() {
+		policy := repository.Policy
+		policy.ScopeBy = b.Policy.ScopeBy
+		return policy
+	}
+	return b.Policy
+}
+
+// snapshotPaths returns the paths of the snapshots of the backup as stored by
+// restic. Data backed up from a filesystem snapshot is stored by the paths
+// inside the snapshot.
+func (b Backup) snapshotPaths() []string {
+	if !b.SnapshotProvider.isEnabled() {
+		return b.Data
+	}
+
+	paths := make([]string, len(b.Data))
+	for i, path := range b.Data {
+		paths[i], _ = b.SnapshotProvider.mapPath(path)
+	}
+	return paths
+}
+
+// HasFilesFrom reports whether the backup reads the files to backup from files.
+func (b Backup) HasFilesFrom() bool {
+	return len(b.FilesFrom) > 0 || len(b.FilesFromVerbatim) > 0
+}
+
 type Defaults struct {
 	Repositories repositoryDefaultable `json:"repositories,omitempty"`
 	Backups      backupDefaultable     `json:"backups,omitempty"`
@@ -91,6 +385,7 @@ type Defaults struct {
 
 type Config struct {
 	ResticExecutable string       `json:"restic_executable,omitempty"`
+	StateDirectory   string       `json:"state_directory,omitempty"`
 	Defaults         Defaults     `json:"defaults,omitempty"`
 	Repositories     []Repository `json:"repositories,omitempty"`
 	Backups          []Backup     `json:"backups,omitempty"`
@@ -116,6 +411,18 @@ func (c *Config) GetBackupByName(name string) *Backup {
 	return nil
 }
 
+// GetCopySource returns the repository snapshots are copied from to the
+// given repository by any of the backups or nil if there is none.
+func (c *Config) GetCopySource(name string) *Repository {
+	for _, backup := range c.Backups {
+		if Contains(backup.CopyTo, name) {
+			return c.GetRepositoryByName(backup.Repositories[0])
+		}
+	}
+
+	return nil
+}
+
 type ValidationError struct {
 	s string
 }
@@ -142,6 +449,7 @@ func LoadFromReader(reader io.Reader) (Config, error) {
 
 	var config = Config{
 		ResticExecutable: "restic",
+		StateDirectory:   defaultStateDirectory(),
 	}
 
 	if err := json.Unmarshal(bytes, &config); err != nil {
@@ -165,6 +473,24 @@ func fillFromDefaults(config *Config) {
 		if config.Repositories[i].Handler.CheckSuccess == "" {
 			config.Repositories[i].Handler.CheckSuccess = config.Defaults.Repositories.Handler.CheckSuccess
 		}
+		if config.Repositories[i].Handler.PruneFailure == "" {
+			config.Repositories[i].Handler.PruneFailure = config.Defaults.Repositories.Handler.PruneFailure
+		}
+		if config.Repositories[i].Handler.PruneSuccess == "" {
+			config.Repositories[i].Handler.PruneSuccess = config.Defaults.Repositories.Handler.PruneSuccess
+		}
+		if config.Repositories[i].Prune.MaxUnused == "" {
+			config.Repositories[i].Prune.MaxUnused = config.Defaults.Repositories.Prune.MaxUnused
+		}
+		if config.Repositories[i].Prune.MaxRepackSize == "" {
+			config.Repositories[i].Prune.MaxRepackSize = config.Defaults.Repositories.Prune.MaxRepackSize
+		}
+		if !config.Repositories[i].Prune.RepackCacheableOnly {
+			config.Repositories[i].Prune.RepackCacheableOnly = config.Defaults.Repositories.Prune.RepackCacheableOnly
+		}
+		if (config.Repositories[i].Prune.MinInterval == jsonutil.Duration{}) {
+			config.Repositories[i].Prune.MinInterval = config.Defaults.Repositories.Prune.MinInterval
+		}
 		if config.Repositories[i].Handler.ForgetFailure == "" {
 			config.Repositories[i].Handler.ForgetFailure = config.Defaults.Repositories.Handler.ForgetFailure
 		}
@@ -195,12 +521,50 @@ func fillFromDefaults(config *Config) {
 		if len(config.Repositories[i].Policy.KeepTags) == 0 {
 			config.Repositories[i].Policy.KeepTags = config.Defaults.Repositories.Policy.KeepTags
 		}
+		if config.Repositories[i].Policy.GroupBy == "" {
+			config.Repositories[i].Policy.GroupBy = config.Defaults.Repositories.Policy.GroupBy
+		}
+		if config.Repositories[i].ForgetGuard.MaxRemovePercentage == 0 {
+			config.Repositories[i].ForgetGuard.MaxRemovePercentage = config.Defaults.Repositories.ForgetGuard.MaxRemovePercentage
+		}
+		if config.Repositories[i].ForgetGuard.MaxRemoveCount == 0 {
+			config.Repositories[i].ForgetGuard.MaxRemoveCount = config.Defaults.Repositories.ForgetGuard.MaxRemoveCount
+		}
+		if (config.Repositories[i].ForgetGuard.MinAge == jsonutil.Duration{}) {
+			config.Repositories[i].ForgetGuard.MinAge = config.Defaults.Repositories.ForgetGuard.MinAge
+		}
 		if config.Repositories[i].LimitDownload == 0 {
 			config.Repositories[i].LimitDownload = config.Defaults.Repositories.LimitDownload
 		}
 		if config.Repositories[i].LimitUpload == 0 {
 			config.Repositories[i].LimitUpload = config.Defaults.Repositories.LimitUpload
 		}
+		if config.Repositories[i].CacheMinFreeSpace == "" {
+			config.Repositories[i].CacheMinFreeSpace = config.Defaults.Repositories.CacheMinFreeSpace
+		}
+		config.Repositories[i].Resources = config.Defaults.Repositories.Resources.override(
+			config.Repositories[i].Resources,
+		)
+		if config.Repositories[i].Lock.Strategy == "" {
+			config.Repositories[i].Lock.Strategy = config.Defaults.Repositories.Lock.Strategy
+		}
+		if (config.Repositories[i].Lock.StaleAfter == jsonutil.Duration{}) {
+			config.Repositories[i].Lock.StaleAfter = config.Defaults.Repositories.Lock.StaleAfter
+		}
+		if (config.Repositories[i].Lock.WaitTimeout == jsonutil.Duration{}) {
+			config.Repositories[i].Lock.WaitTimeout = config.Defaults.Repositories.Lock.WaitTimeout
+		}
+
+		// builtin defaults
+		if config.Repositories[i].Lock.Strategy == "" {
+			config.Repositories[i].Lock.Strategy = LockStrategyStaleOnly
+		}
+		if (config.Repositories[i].Lock.StaleAfter == jsonutil.Duration{}) {
+			config.Repositories[i].Lock.StaleAfter = jsonutil.FromDuration(minLockStaleAfter)
+		}
+		if (config.Repositories[i].Lock.WaitTimeout == jsonutil.Duration{}) {
+			config.Repositories[i].Lock.WaitTimeout = jsonutil.FromDuration(defaultLockWaitTimeout)
+		}
 	}
 	for i := range config.Backups {
 		if config.Backups[i].Handler.Before == "" {
@@ -221,12 +585,82 @@ func fillFromDefaults(config *Config) {
 		if config.Backups[i].Handler.AgeError == "" {
 			config.Backups[i].Handler.AgeError = config.Defaults.Backups.Handler.AgeError
 		}
+		if config.Backups[i].Handler.Skipped == "" {
+			config.Backups[i].Handler.Skipped = config.Defaults.Backups.Handler.Skipped
+		}
+		if config.Backups[i].Handler.CopySuccess == "" {
+			config.Backups[i].Handler.CopySuccess = config.Defaults.Backups.Handler.CopySuccess
+		}
+		if config.Backups[i].Handler.CopyFailure == "" {
+			config.Backups[i].Handler.CopyFailure = config.Defaults.Backups.Handler.CopyFailure
+		}
+		if config.Backups[i].Handler.VerifySuccess == "" {
+			config.Backups[i].Handler.VerifySuccess = config.Defaults.Backups.Handler.VerifySuccess
+		}
+		if config.Backups[i].Handler.VerifyFailure == "" {
+			config.Backups[i].Handler.VerifyFailure = config.Defaults.Backups.Handler.VerifyFailure
+		}
 		if (config.Backups[i].Age.Warn == jsonutil.Duration{}) {
 			config.Backups[i].Age.Warn = config.Defaults.Backups.Age.Warn
 		}
 		if (config.Backups[i].Age.Error == jsonutil.Duration{}) {
 			config.Backups[i].Age.Error = config.Defaults.Backups.Age.Error
 		}
+		if config.Backups[i].StdinFailure == "" {
+			config.Backups[i].StdinFailure = config.Defaults.Backups.StdinFailure
+		}
+		if len(config.Backups[i].ExcludeFile) == 0 {
+			config.Backups[i].ExcludeFile = config.Defaults.Backups.ExcludeFile
+		}
+		if len(config.Backups[i].IExclude) == 0 {
+			config.Backups[i].IExclude = config.Defaults.Backups.IExclude
+		}
+		if len(config.Backups[i].ExcludeIfPresent) == 0 {
+			config.Backups[i].ExcludeIfPresent = config.Defaults.Backups.ExcludeIfPresent
+		}
+		if !config.Backups[i].ExcludeCaches {
+			config.Backups[i].ExcludeCaches = config.Defaults.Backups.ExcludeCaches
+		}
+		if config.Backups[i].ExcludeLargerThan == "" {
+			config.Backups[i].ExcludeLargerThan = config.Defaults.Backups.ExcludeLargerThan
+		}
+		if config.Backups[i].Host == "" {
+			config.Backups[i].Host = config.Defaults.Backups.Host
+		}
+		// files from defaults make no sense for backups of stdin data
+		if len(config.Backups[i].GetStdinSources()) == 0 {
+			if len(config.Backups[i].FilesFrom) == 0 {
+				config.Backups[i].FilesFrom = config.Defaults.Backups.FilesFrom
+			}
+			if len(config.Backups[i].FilesFromVerbatim) == 0 {
+				config.Backups[i].FilesFromVerbatim = config.Defaults.Backups.FilesFromVerbatim
+			}
+		}
+
+		// builtin defaults
+		if config.Backups[i].StdinFailure == "" {
+			config.Backups[i].StdinFailure = StdinFailureTag
+		}
+		if config.Backups[i].Verify.Files == 0 && config.Backups[i].Verify.Percentage == 0 {
+			config.Backups[i].Verify.Files = defaultVerifyFiles
+		}
+		fillSnapshotProviderDefaults(&config.Backups[i])
+	}
+}
+
+func fillSnapshotProviderDefaults(backup *Backup) {
+	provider := &backup.SnapshotProvider
+
+	if provider.Type == "" && *provider != (SnapshotProvider{}) {
+		provider.Type = SnapshotProviderCustom
+	}
+
+	if provider.Type == SnapshotProviderZFS && provider.MountPath == "" && provider.Path != "" {
+		provider.MountPath = filepath.Join(provider.Path, ".zfs", "snapshot", provider.snapshotName(backup.Name))
+	}
+
+	if provider.Type == SnapshotProviderLVM && provider.Size == "" {
+		provider.Size = defaultLVMSnapshotSize
 	}
 }
 
@@ -280,6 +714,160 @@ func validateRepository(repo *Repository) error {
 		return ValidationError{"Repository check read data percentage outside expected range [0,100]"}
 	}
 
+	if _, err := parseSize(repo.Check.ReadDataSize); repo.Check.ReadDataSize != "" && err != nil {
+		return ValidationError{fmt.Sprintf("Repository check read_data_size %s is invalid.", repo.Check.ReadDataSize)}
+	}
+
+	if repo.Check.ReadDataSize != "" && repo.Check.ReadDataPercentage > 0 {
+		return ValidationError{"Repository check may either use read_data_percentage or read_data_size."}
+	}
+
+	switch repo.Lock.Strategy {
+	case LockStrategyNone, LockStrategyStaleOnly, LockStrategyWait:
+	default:
+		return ValidationError{fmt.Sprintf("Repository lock strategy %s is invalid.", repo.Lock.Strategy)}
+	}
+
+	if repo.Lock.StaleAfter.Duration < minLockStaleAfter {
+		return ValidationError{fmt.Sprintf("Repository lock stale_after is below %s.", minLockStaleAfter)}
+	}
+
+	if repo.ForgetGuard.MaxRemovePercentage > 100 {
+		return ValidationError{"Repository forget_guard max_remove_percentage outside expected range [0,100]"}
+	}
+
+	if repo.ForgetGuard.MinAge.Duration < 0 {
+		return ValidationError{"Repository forget_guard min_age must not be negative."}
+	}
+
+	if repo.Prune.MaxUnused != "" && !maxUnusedPattern.MatchString(repo.Prune.MaxUnused) {
+		return ValidationError{fmt.Sprintf("Repository prune max_unused %s is invalid.", repo.Prune.MaxUnused)}
+	}
+
+	if repo.Prune.MaxRepackSize != "" && !sizePattern.MatchString(repo.Prune.MaxRepackSize) {
+		return ValidationError{fmt.Sprintf("Repository prune max_repack_size %s is invalid.", repo.Prune.MaxRepackSize)}
+	}
+
+	if repo.Prune.MinInterval.Duration < 0 {
+		return ValidationError{"Repository prune min_interval must not be negative."}
+	}
+
+	if !isValidGroupBy(repo.Policy.GroupBy) {
+		return ValidationError{fmt.Sprintf("Repository policy group_by %s is invalid.", repo.Policy.GroupBy)}
+	}
+
+	if !isValidWithin(repo.Policy.KeepWithin) {
+		return ValidationError{fmt.Sprintf("Repository policy keep_within %s is invalid.", repo.Policy.KeepWithin)}
+	}
+
+	if repo.Policy.ScopeBy != "" {
+		return ValidationError{"Repository policy scope_by is only supported by backup policies."}
+	}
+
+	if repo.LimitDownload < 0 || repo.LimitUpload < 0 {
+		return ValidationError{"Repository limit_download and limit_upload must not be negative."}
+	}
+
+	if repo.CacheMinFreeSpace != "" {
+		if _, err := parseSize(repo.CacheMinFreeSpace); err != nil {
+			return ValidationError{fmt.Sprintf("Repository cache_min_free_space %s is invalid.", repo.CacheMinFreeSpace)}
+		}
+	}
+
+	return validateResources(repo.Resources)
+}
+
+func validateSnapshotProvider(backup *Backup) error {
+
+	provider := backup.SnapshotProvider
+
+	switch provider.Type {
+	case "":
+		return nil
+	case SnapshotProviderCustom:
+		if provider.Create == "" {
+			return ValidationError{"Backup snapshot_provider needs a create command."}
+		}
+	case SnapshotProviderBtrfs:
+	case SnapshotProviderZFS, SnapshotProviderLVM:
+		if provider.Volume == "" {
+			return ValidationError{fmt.Sprintf("Backup snapshot_provider %s needs a volume.", provider.Type)}
+		}
+	default:
+		return ValidationError{fmt.Sprintf("Backup snapshot_provider type %s is invalid.", provider.Type)}
+	}
+
+	if !filepath.IsAbs(provider.Path) || !filepath.IsAbs(provider.MountPath) {
+		return ValidationError{"Backup snapshot_provider needs an absolute path and mount_path."}
+	}
+
+	if provider.Type == SnapshotProviderLVM && !sizePattern.MatchString(provider.Size) {
+		return ValidationError{fmt.Sprintf("Backup snapshot_provider size %s is invalid.", provider.Size)}
+	}
+
+	if len(backup.Data) == 0 || backup.HasFilesFrom() {
+		return ValidationError{"Backup snapshot_provider only supports data."}
+	}
+
+	for _, path := range backup.Data {
+		if _, ok := provider.mapPath(path); !ok {
+			return ValidationError{fmt.Sprintf("Backup data %s is not within snapshot_provider path.", path)}
+		}
+	}
+
+	return nil
+}
+
+func validateResources(resources Resources) error {
+
+	if resources.Nice < -20 || resources.Nice > 19 {
+		return ValidationError{fmt.Sprintf("Resources nice %d is not between -20 and 19.", resources.Nice)}
+	}
+
+	switch resources.IONiceClass {
+	case "", IONiceClassRealtime, IONiceClassBestEffort:
+	case IONiceClassIdle:
+		if resources.IONiceLevel != 0 {
+			return ValidationError{"Resources ionice_level can't be used with ionice class idle."}
+		}
+	default:
+		return ValidationError{fmt.Sprintf("Resources ionice_class %s is invalid.", resources.IONiceClass)}
+	}
+
+	if resources.IONiceLevel < 0 || resources.IONiceLevel > 7 {
+		return ValidationError{fmt.Sprintf("Resources ionice_level %d is not between 0 and 7.", resources.IONiceLevel)}
+	}
+
+	if resources.IONiceClass == "" && resources.IONiceLevel != 0 {
+		return ValidationError{"Resources ionice_level needs an ionice_class."}
+	}
+
+	if resources.GOMAXPROCS < 0 || resources.ReadConcurrency < 0 {
+		return ValidationError{"Resources gomaxprocs and read_concurrency must not be negative."}
+	}
+
+	if runtime.GOOS == "windows" && (resources.Nice != 0 || resources.IONiceClass != "") {
+		fmt.Println("Warning: nice and ionice are not supported on windows and will be ignored.")
+	}
+
+	return nil
+}
+
+func validateDatabase(database Database) error {
+
+	switch database.Type {
+	case DatabasePostgres, DatabaseMySQL:
+		if database.Name == "" {
+			return ValidationError{fmt.Sprintf("Backup database of type %s needs a name.", database.Type)}
+		}
+	case DatabaseSQLite:
+		if database.Path == "" {
+			return ValidationError{"Backup database of type sqlite needs a path."}
+		}
+	default:
+		return ValidationError{fmt.Sprintf("Backup database type %s is invalid.", database.Type)}
+	}
+
 	return nil
 }
 
@@ -293,21 +881,58 @@ func validateBackup(backup *Backup, repoNames map[string]bool) error {
 		return ValidationError{"Backup name contains invalid character."}
 	}
 
+	if backup.Name == strings.TrimPrefix(IncompleteTag, BackupTagPrefix) {
+		return ValidationError{fmt.Sprintf("Backup name %s is reserved.", backup.Name)}
+	}
+
 	if len(backup.Repositories) == 0 {
 		return ValidationError{"Backup has no repository."}
 	}
 
+	for _, tag := range backup.Tags {
+		if strings.HasPrefix(tag, BackupTagPrefix) {
+			return ValidationError{fmt.Sprintf("Backup tag %s must not start with %s.", tag, BackupTagPrefix)}
+		}
+	}
+
 	for _, repo := range backup.Repositories {
 		if _, ok := repoNames[repo]; !ok {
 			return ValidationError{fmt.Sprintf("Backup repository %s not defined.", repo)}
 		}
 	}
 
-	if len(backup.Data) > 0 && backup.DataStdinCommand != "" {
+	if len(backup.CopyTo) > 0 && len(backup.Repositories) > 1 {
+		return ValidationError{"Backup with copy_to needs exactly one repository."}
+	}
+
+	copyTargets := make(map[string]bool)
+
+	for _, repo := range backup.CopyTo {
+		if _, ok := repoNames[repo]; !ok {
+			return ValidationError{fmt.Sprintf("Backup copy_to repository %s not defined.", repo)}
+		}
+
+		if Contains(backup.Repositories, repo) || copyTargets[repo] {
+			return ValidationError{fmt.Sprintf("Backup copy_to repository %s is used multiple times.", repo)}
+		}
+		copyTargets[repo] = true
+	}
+
+	for _, database := range backup.Databases {
+		if err := validateDatabase(database); err != nil {
+			return err
+		}
+	}
+
+	hasStdin := len(backup.GetStdinSources()) > 0
+
+	hasFiles := len(backup.Data) > 0 || backup.HasFilesFrom()
+
+	if hasFiles && hasStdin {
 		return ValidationError{"Backup can't use data from filesystem and stdin."}
 	}
 
-	if len(backup.Data) == 0 && backup.DataStdinCommand == "" {
+	if !hasFiles && !hasStdin {
 		return ValidationError{"Backup needs something to backup."}
 	}
 
@@ -315,10 +940,80 @@ func validateBackup(backup *Backup, repoNames map[string]bool) error {
 		return ValidationError{"Backup from stdin needs a stdin filename."}
 	}
 
+	if !hasStdin && backup.StdinValidation.isEnabled() {
+		return ValidationError{"Backup stdin validation needs a stdin command."}
+	}
+
+	filenames := make(map[string]bool)
+
+	for _, source := range backup.GetStdinSources() {
+		if source.Command == "" {
+			return ValidationError{"Backup stdin source has no command."}
+		}
+
+		if source.Filename == "" {
+			return ValidationError{"Backup stdin source needs a filename."}
+		}
+
+		if filenames[source.Filename] {
+			return ValidationError{fmt.Sprintf("Backup stdin filename %s is not unique.", source.Filename)}
+		}
+		filenames[source.Filename] = true
+	}
+
+	if err := validateSnapshotProvider(backup); err != nil {
+		return err
+	}
+
+	if backup.ExcludeLargerThan != "" && !sizePattern.MatchString(backup.ExcludeLargerThan) {
+		return ValidationError{fmt.Sprintf("Backup exclude_larger_than %s is invalid.", backup.ExcludeLargerThan)}
+	}
+
+	if backup.StdinFailure != StdinFailureTag && backup.StdinFailure != StdinFailureForget {
+		return ValidationError{fmt.Sprintf("Backup stdin_failure %s is invalid.", backup.StdinFailure)}
+	}
+
 	if backup.Age.Error.Nanoseconds() < backup.Age.Warn.Nanoseconds() {
 		return ValidationError{"Backup age error limit < warn limit."}
 	}
 
+	if backup.Conditions.MinInterval.Duration < 0 || backup.Conditions.RandomDelay.Duration < 0 {
+		return ValidationError{"Backup conditions min_interval and random_delay must not be negative."}
+	}
+
+	if backup.LimitDownload < 0 || backup.LimitUpload < 0 {
+		return ValidationError{"Backup limit_download and limit_upload must not be negative."}
+	}
+
+	if !isValidGroupBy(backup.Policy.GroupBy) {
+		return ValidationError{fmt.Sprintf("Backup policy group_by %s is invalid.", backup.Policy.GroupBy)}
+	}
+
+	if !isValidWithin(backup.Policy.KeepWithin) {
+		return ValidationError{fmt.Sprintf("Backup policy keep_within %s is invalid.", backup.Policy.KeepWithin)}
+	}
+
+	if !isValidScopeBy(backup.Policy.ScopeBy) {
+		return ValidationError{fmt.Sprintf("Backup policy scope_by %s is invalid.", backup.Policy.ScopeBy)}
+	}
+
+	if Contains(scopeByFields(backup.Policy.ScopeBy), "paths") &&
+		(len(backup.GetStdinSources()) > 0 || backup.HasFilesFrom() || len(backup.Data) == 0) {
+		return ValidationError{"Backup policy scope_by paths requires a backup of data paths."}
+	}
+
+	if backup.Verify.Files < 0 || backup.Verify.Percentage < 0 || backup.Verify.Percentage > 100 {
+		return ValidationError{"Backup verify files or percentage outside expected range."}
+	}
+
+	if backup.Verify.Files > 0 && backup.Verify.Percentage > 0 {
+		return ValidationError{"Backup verify can't use files and percentage."}
+	}
+
+	if err := validateResources(backup.Resources); err != nil {
+		return err
+	}
+
 	if runtime.GOOS == "windows" && backup.OneFileSystem {
 		fmt.Println("Warning: restic option --one-file-system does not work as expected on windows yet.")
 	}
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -778,3 +779,937 @@ func TestLoadConfigWithSomeDefaults(t *testing.T) {
 	assert.Equal(t, c.Backups[0].Age.Warn.Duration, time.Duration(0))
 	assert.Equal(t, c.Backups[0].Age.Error.Duration, err)
 }
+
+func TestLoadConfigWithLock(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": {
+				"lock": {
+					"wait_timeout": "2h"
+				}
+			}
+		},
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			},
+			{
+				"name": "test2",
+				"url": "/home/test/repos/test2",
+				"password": "2",
+				"lock": {
+					"strategy": "wait",
+					"stale_after": "1h",
+					"wait_timeout": "10m"
+				}
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, LockStrategyStaleOnly, c.Repositories[0].Lock.Strategy)
+	assert.Equal(t, 30*time.Minute, c.Repositories[0].Lock.StaleAfter.Duration)
+	assert.Equal(t, 2*time.Hour, c.Repositories[0].Lock.WaitTimeout.Duration)
+
+	assert.Equal(t, LockStrategyWait, c.Repositories[1].Lock.Strategy)
+	assert.Equal(t, time.Hour, c.Repositories[1].Lock.StaleAfter.Duration)
+	assert.Equal(t, 10*time.Minute, c.Repositories[1].Lock.WaitTimeout.Duration)
+}
+
+func TestLoadConfigWithInvalidLockShouldFail(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1",
+				"lock": {
+					"strategy": "always"
+				}
+			}
+		]
+	}`)
+
+	_, error := LoadFromReader(reader)
+	assert.True(t, error != nil)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1",
+				"lock": {
+					"stale_after": "5m"
+				}
+			}
+		]
+	}`)
+
+	_, error = LoadFromReader(reader)
+	assert.True(t, error != nil)
+}
+
+func TestLoadConfigWithStdinFailure(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "mysql",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "mysqldump",
+				"stdin_filename": "mysqldump.sql"
+			},
+			{
+				"name": "postgres",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "pg_dumpall",
+				"stdin_filename": "pg_dumpall.sql",
+				"stdin_failure": "forget"
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+	assert.Equal(t, StdinFailureTag, c.Backups[0].StdinFailure)
+	assert.Equal(t, StdinFailureForget, c.Backups[1].StdinFailure)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "mysql",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "mysqldump",
+				"stdin_filename": "mysqldump.sql",
+				"stdin_failure": "ignore"
+			}
+		]
+	}`)
+
+	_, error = LoadFromReader(reader)
+	assert.True(t, error != nil)
+}
+
+func TestLoadConfigWithStdinValidation(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "mysql",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "mysqldump",
+				"stdin_filename": "mysqldump.sql",
+				"stdin_validation": {
+					"min_bytes": 1024,
+					"trailing_marker": "-- Dump completed",
+					"command": "validate-dump.sh"
+				}
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+	assert.Equal(t, uint64(1024), c.Backups[0].StdinValidation.MinBytes)
+	assert.Equal(t, "-- Dump completed", c.Backups[0].StdinValidation.TrailingMarker)
+	assert.Equal(t, "validate-dump.sh", c.Backups[0].StdinValidation.Command)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "etc",
+				"repositories": [ "test1" ],
+				"data": [ "/etc" ],
+				"stdin_validation": {
+					"min_bytes": 1024
+				}
+			}
+		]
+	}`)
+
+	_, error = LoadFromReader(reader)
+	assert.True(t, error != nil)
+}
+
+func TestLoadConfigWithStdinSources(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "databases",
+				"repositories": [ "test1" ],
+				"stdin_sources": [
+					{ "command": "pg_dump app", "filename": "app.sql" },
+					{
+						"command": "pg_dump wiki",
+						"filename": "wiki.sql",
+						"validation": { "min_bytes": 10 }
+					}
+				],
+				"stdin_validation": {
+					"trailing_marker": "-- PostgreSQL database dump complete"
+				}
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	sources := c.Backups[0].GetStdinSources()
+	assert.Equal(t, 2, len(sources))
+	assert.Equal(t, "pg_dump app", sources[0].Command)
+	assert.Equal(t, "app.sql", sources[0].Filename)
+	assert.Equal(t, "-- PostgreSQL database dump complete", sources[0].Validation.TrailingMarker)
+	assert.Equal(t, uint64(10), sources[1].Validation.MinBytes)
+	assert.Equal(t, "", sources[1].Validation.TrailingMarker)
+}
+
+func TestLoadConfigWithInvalidStdinSourcesShouldFail(t *testing.T) {
+	backups := []string{
+		`"data": [ "/etc" ], "stdin_sources": [ { "command": "a", "filename": "a" } ]`,
+		`"stdin_sources": [ { "command": "a" } ]`,
+		`"stdin_sources": [ { "filename": "a" } ]`,
+		`"stdin_sources": [ { "command": "a", "filename": "a" }, { "command": "b", "filename": "a" } ]`,
+		`"data_stdin_command": "a", "stdin_filename": "a", "stdin_sources": [ { "command": "b", "filename": "a" } ]`,
+	}
+
+	for _, backup := range backups {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "name": "databases", "repositories": [ "test1" ], ` + backup + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, backup)
+	}
+}
+
+func TestLoadConfigWithExcludeOptions(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"backups": {
+				"exclude_caches": true,
+				"exclude_if_present": [ ".nobackup" ],
+				"exclude_larger_than": "2G",
+				"files_from": [ "/etc/rester/default-files.txt" ]
+			}
+		},
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "home",
+				"repositories": [ "test1" ],
+				"exclude_file": [ "/home/test/.excludes" ],
+				"iexclude": [ "*.TMP" ],
+				"exclude_larger_than": "500M",
+				"files_from_verbatim": [ "/home/test/files.txt" ]
+			},
+			{
+				"name": "mysql",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "mysqldump",
+				"stdin_filename": "mysqldump.sql"
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, []string{"/home/test/.excludes"}, c.Backups[0].ExcludeFile)
+	assert.Equal(t, []string{"*.TMP"}, c.Backups[0].IExclude)
+	assert.Equal(t, []string{".nobackup"}, c.Backups[0].ExcludeIfPresent)
+	assert.Equal(t, true, c.Backups[0].ExcludeCaches)
+	assert.Equal(t, "500M", c.Backups[0].ExcludeLargerThan)
+	assert.Equal(t, []string{"/etc/rester/default-files.txt"}, c.Backups[0].FilesFrom)
+	assert.Equal(t, []string{"/home/test/files.txt"}, c.Backups[0].FilesFromVerbatim)
+
+	assert.Equal(t, "2G", c.Backups[1].ExcludeLargerThan)
+	assert.Equal(t, 0, len(c.Backups[1].FilesFrom))
+}
+
+func TestLoadConfigWithInvalidExcludeOptionsShouldFail(t *testing.T) {
+	backups := []string{
+		`"data": [ "/etc" ], "exclude_larger_than": "2 GB"`,
+		`"data_stdin_command": "a", "stdin_filename": "a", "files_from": [ "/files.txt" ]`,
+	}
+
+	for _, backup := range backups {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "name": "home", "repositories": [ "test1" ], ` + backup + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, backup)
+	}
+}
+
+func TestLoadConfigWithHost(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"backups": {
+				"host": "nas"
+			}
+		},
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "etc",
+				"repositories": [ "test1" ],
+				"data": [ "/etc" ]
+			},
+			{
+				"name": "home",
+				"repositories": [ "test1" ],
+				"data": [ "/home" ],
+				"host": "workstation"
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	host, _ := c.Backups[0].GetHost()
+	assert.Equal(t, "nas", host)
+	host, _ = c.Backups[1].GetHost()
+	assert.Equal(t, "workstation", host)
+
+	hostname, _ := os.Hostname()
+	host, _ = Backup{}.GetHost()
+	assert.Equal(t, hostname, host)
+}
+
+func TestLoadConfigWithReservedNamesShouldFail(t *testing.T) {
+	backups := []string{
+		`"name": "incomplete", "data": [ "/etc" ]`,
+		`"name": "etc", "data": [ "/etc" ], "tags": [ "rester:etc" ]`,
+	}
+
+	for _, backup := range backups {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "repositories": [ "test1" ], ` + backup + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, backup)
+	}
+}
+
+func TestLoadConfigWithSnapshotProvider(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "db",
+				"repositories": [ "test1" ],
+				"data": [ "/srv/db" ],
+				"snapshot_provider": {
+					"type": "zfs",
+					"volume": "tank/srv",
+					"path": "/srv"
+				}
+			},
+			{
+				"name": "custom",
+				"repositories": [ "test1" ],
+				"data": [ "/var/lib/app" ],
+				"snapshot_provider": {
+					"path": "/var/lib/app",
+					"mount_path": "/mnt/app",
+					"create": "snap.sh create",
+					"destroy": "snap.sh destroy"
+				}
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+	assert.Equal(t, SnapshotProviderZFS, c.Backups[0].SnapshotProvider.Type)
+	assert.Equal(t, "/srv/.zfs/snapshot/rester-db", c.Backups[0].SnapshotProvider.MountPath)
+	assert.Equal(t, SnapshotProviderCustom, c.Backups[1].SnapshotProvider.Type)
+	assert.Equal(t, "", c.Backups[1].SnapshotProvider.Size)
+}
+
+func TestLoadConfigWithInvalidSnapshotProviderShouldFail(t *testing.T) {
+	backups := []string{
+		`"data": [ "/srv" ], "snapshot_provider": { "type": "xfs", "path": "/srv", "mount_path": "/mnt" }`,
+		`"data": [ "/srv" ], "snapshot_provider": { "path": "/srv", "mount_path": "/mnt" }`,
+		`"data": [ "/srv" ], "snapshot_provider": { "type": "lvm", "path": "/srv", "mount_path": "/mnt" }`,
+		`"data": [ "/srv" ], "snapshot_provider": { "type": "btrfs", "path": "/srv" }`,
+		`"data": [ "/etc" ], "snapshot_provider": { "type": "btrfs", "path": "/srv", "mount_path": "/mnt" }`,
+		`"data_stdin_command": "a", "stdin_filename": "a", "snapshot_provider": { "type": "btrfs", "path": "/srv", "mount_path": "/mnt" }`,
+	}
+
+	for _, backup := range backups {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "name": "db", "repositories": [ "test1" ], ` + backup + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, backup)
+	}
+}
+
+func TestLoadConfigWithDatabases(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "databases",
+				"repositories": [ "test1" ],
+				"databases": [
+					{ "type": "postgres", "name": "shop", "user": "backup", "password": "secret" },
+					{ "type": "mysql", "name": "wiki" },
+					{ "type": "sqlite", "path": "/var/lib/app/app.db" }
+				]
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	sources := c.Backups[0].GetStdinSources()
+	assert.Equal(t, 3, len(sources))
+	assert.Equal(t, "shop.sql", sources[0].Filename)
+	assert.Equal(t, "wiki.sql", sources[1].Filename)
+	assert.Equal(t, "app.sql", sources[2].Filename)
+
+	backups := []string{
+		`"databases": [ { "type": "oracle", "name": "shop" } ]`,
+		`"databases": [ { "type": "postgres" } ]`,
+		`"databases": [ { "type": "sqlite" } ]`,
+		`"databases": [ { "type": "postgres", "name": "shop" }, { "type": "mysql", "name": "shop" } ]`,
+		`"data": [ "/etc" ], "databases": [ { "type": "postgres", "name": "shop" } ]`,
+	}
+
+	for _, backup := range backups {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "name": "databases", "repositories": [ "test1" ], ` + backup + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, backup)
+	}
+}
+
+func TestLoadConfigWithResources(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": {
+				"resources": { "nice": 10, "ionice_class": "idle" }
+			}
+		},
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1",
+				"resources": { "gomaxprocs": 2 }
+			}
+		],
+		"backups": [
+			{
+				"name": "etc",
+				"repositories": [ "test1" ],
+				"data": [ "/etc" ],
+				"limit_upload": 500,
+				"resources": { "nice": 19, "read_concurrency": 1 }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+	assert.Equal(t, Resources{Nice: 10, IONiceClass: IONiceClassIdle, GOMAXPROCS: 2}, c.Repositories[0].Resources)
+	assert.Equal(t, 500, c.Backups[0].LimitUpload)
+	assert.Equal(t, Resources{Nice: 19, ReadConcurrency: 1}, c.Backups[0].Resources)
+
+	resources := []string{
+		`{ "nice": 20 }`,
+		`{ "ionice_class": "lowest" }`,
+		`{ "ionice_class": "best-effort", "ionice_level": 8 }`,
+		`{ "ionice_class": "idle", "ionice_level": 4 }`,
+		`{ "ionice_level": 4 }`,
+		`{ "gomaxprocs": -1 }`,
+	}
+
+	for _, r := range resources {
+		reader := strings.NewReader(`{
+			"repositories": [ { "name": "test1", "url": "/home/test/repos/test1", "password": "1" } ],
+			"backups": [ { "name": "etc", "repositories": [ "test1" ], "data": [ "/etc" ], "resources": ` + r + ` } ]
+		}`)
+
+		_, error := LoadFromReader(reader)
+		assert.True(t, error != nil, r)
+	}
+}
+
+func TestLoadConfigWithConditions(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{
+				"name": "test1",
+				"url": "/home/test/repos/test1",
+				"password": "1"
+			}
+		],
+		"backups": [
+			{
+				"name": "laptop",
+				"repositories": [ "test1" ],
+				"data": [ "/home" ],
+				"conditions": {
+					"min_interval": "6h",
+					"require_path": [ "/mnt/usb/rester" ],
+					"require_command": "ping -c 1 nas",
+					"not_on_battery": true,
+					"random_delay": "10m"
+				},
+				"handler": { "skipped": "notify.sh {{.Error}}" }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	conditions := c.Backups[0].Conditions
+	assert.Equal(t, 6*time.Hour, conditions.MinInterval.Duration)
+	assert.Equal(t, []string{"/mnt/usb/rester"}, conditions.RequirePath)
+	assert.Equal(t, "ping -c 1 nas", conditions.RequireCommand)
+	assert.True(t, conditions.NotOnBattery)
+	assert.Equal(t, 10*time.Minute, conditions.RandomDelay.Duration)
+	assert.Equal(t, "notify.sh {{.Error}}", c.Backups[0].Handler.Skipped)
+}
+
+func TestLoadConfigWithCopyTo(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{ "name": "local", "url": "/home/test/repos/local", "password": "1" },
+			{ "name": "nas", "url": "sftp:nas:/repos/test", "password": "2" },
+			{ "name": "cloud", "url": "s3:s3.amazonaws.com/test", "password": "3" }
+		],
+		"backups": [
+			{
+				"name": "laptop",
+				"repositories": [ "local" ],
+				"copy_to": [ "nas", "cloud" ],
+				"data": [ "/home" ],
+				"handler": { "copy_failure": "notify.sh {{.RepositoryName}}" }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, []string{"nas", "cloud"}, c.Backups[0].CopyTo)
+	assert.Equal(t, []string{"local", "nas", "cloud"}, c.Backups[0].GetTargetRepositories())
+	assert.Equal(t, "notify.sh {{.RepositoryName}}", c.Backups[0].Handler.CopyFailure)
+
+	assert.Equal(t, "local", c.GetCopySource("nas").Name)
+	assert.Equal(t, "local", c.GetCopySource("cloud").Name)
+	assert.Nil(t, c.GetCopySource("local"))
+}
+
+func TestLoadConfigWithInvalidCopyToShouldFail(t *testing.T) {
+	for _, backup := range []string{
+		`"repositories": [ "local" ], "copy_to": [ "missing" ]`,
+		`"repositories": [ "local" ], "copy_to": [ "local" ]`,
+		`"repositories": [ "local" ], "copy_to": [ "nas", "nas" ]`,
+		`"repositories": [ "local", "nas" ], "copy_to": [ "cloud" ]`,
+	} {
+		reader := strings.NewReader(`{
+			"repositories": [
+				{ "name": "local", "url": "/home/test/repos/local", "password": "1" },
+				{ "name": "nas", "url": "sftp:nas:/repos/test", "password": "2" },
+				{ "name": "cloud", "url": "s3:s3.amazonaws.com/test", "password": "3" }
+			],
+			"backups": [
+				{ "name": "laptop", "data": [ "/home" ], ` + backup + ` }
+			]
+		}`)
+
+		_, err := LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, backup)
+	}
+}
+
+func TestLoadConfigWithVerify(t *testing.T) {
+	reader := strings.NewReader(`{
+		"state_directory": "/var/lib/rester",
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" }
+		],
+		"backups": [
+			{
+				"name": "laptop",
+				"repositories": [ "test1" ],
+				"data": [ "/home" ],
+				"verify": { "percentage": 5, "after_backup": true },
+				"handler": { "verify_failure": "notify.sh {{.Error}}" }
+			},
+			{
+				"name": "etc",
+				"repositories": [ "test1" ],
+				"data": [ "/etc" ]
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, "/var/lib/rester", c.StateDirectory)
+	assert.Equal(t, BackupVerify{Percentage: 5, AfterBackup: true}, c.Backups[0].Verify)
+	assert.Equal(t, "notify.sh {{.Error}}", c.Backups[0].Handler.VerifyFailure)
+	assert.Equal(t, BackupVerify{Files: defaultVerifyFiles}, c.Backups[1].Verify)
+}
+
+func TestLoadConfigWithInvalidVerifyShouldFail(t *testing.T) {
+	for _, verify := range []string{
+		`{ "files": -1 }`,
+		`{ "percentage": 101 }`,
+		`{ "files": 10, "percentage": 5 }`,
+	} {
+		reader := strings.NewReader(`{
+			"repositories": [
+				{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" }
+			],
+			"backups": [
+				{ "name": "laptop", "repositories": [ "test1" ], "data": [ "/home" ], "verify": ` + verify + ` }
+			]
+		}`)
+
+		_, err := LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, verify)
+	}
+}
+
+func TestLoadConfigWithBackupPolicy(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": { "policy": { "group_by": "host,paths" } }
+		},
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "policy": { "keep_daily": 7 } }
+		],
+		"backups": [
+			{
+				"name": "db",
+				"repositories": [ "test1" ],
+				"data_stdin_command": "pg_dumpall",
+				"stdin_filename": "all.sql",
+				"policy": { "keep_hourly": 48, "keep_daily": 30, "group_by": "tags" }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, "host,paths", c.Repositories[0].Policy.GroupBy)
+	assert.Equal(t, Policy{KeepHourly: 48, KeepDaily: 30, GroupBy: "tags"}, c.Backups[0].Policy)
+}
+
+func TestLoadConfigWithInvalidGroupByShouldFail(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" }
+		],
+		"backups": [
+			{ "name": "laptop", "repositories": [ "test1" ], "data": [ "/home" ], "policy": { "keep_last": 3, "group_by": "hostname" } }
+		]
+	}`)
+
+	_, err := LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "policy": { "group_by": "time" } }
+		]
+	}`)
+
+	_, err = LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+}
+
+func TestLoadConfigWithInvalidKeepWithinShouldFail(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "policy": { "keep_within": "7 days" } }
+		]
+	}`)
+
+	_, err := LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" }
+		],
+		"backups": [
+			{ "name": "laptop", "repositories": [ "test1" ], "data": [ "/home" ], "policy": { "keep_within": "2w" } }
+		]
+	}`)
+
+	_, err = LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+}
+
+func TestLoadConfigWithScopeBy(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "policy": { "keep_daily": 7 } }
+		],
+		"backups": [
+			{ "name": "laptop", "repositories": [ "test1" ], "data": [ "/home" ], "policy": { "scope_by": "host,paths" } }
+		]
+	}`)
+
+	c, err := LoadFromReader(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, Policy{KeepDaily: 7, ScopeBy: "host,paths"}, c.Backups[0].GetPolicy(c.Repositories[0]))
+
+	for _, config := range []string{
+		`"repositories": [ "test1" ], "data": [ "/home" ], "policy": { "scope_by": "hostname" }`,
+		`"repositories": [ "test1" ], "data_stdin_command": "pg_dumpall", "stdin_filename": "all.sql", "policy": { "scope_by": "paths" }`,
+		`"repositories": [ "test1" ], "files_from": [ "/etc/files" ], "policy": { "scope_by": "tag,paths" }`,
+	} {
+		reader = strings.NewReader(`{
+			"repositories": [
+				{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" }
+			],
+			"backups": [
+				{ "name": "laptop", ` + config + ` }
+			]
+		}`)
+
+		_, err = LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, config)
+	}
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "policy": { "scope_by": "host" } }
+		]
+	}`)
+
+	_, err = LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+}
+
+func TestLoadConfigWithCacheMinFreeSpace(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": { "cache_min_free_space": "1G" }
+		},
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" },
+			{ "name": "test2", "url": "/home/test/repos/test2", "password": "2", "cache_min_free_space": "500M" }
+		]
+	}`)
+
+	c, err := LoadFromReader(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "1G", c.Repositories[0].CacheMinFreeSpace)
+	assert.Equal(t, "500M", c.Repositories[1].CacheMinFreeSpace)
+
+	reader = strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "cache_min_free_space": "1 GiB" }
+		]
+	}`)
+
+	_, err = LoadFromReader(reader)
+	assert.IsType(t, ValidationError{}, err)
+}
+
+func TestLoadConfigWithPrune(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": {
+				"prune": { "max_unused": "5%", "min_interval": "168h" },
+				"handler": { "prune_failure": "notify.sh {{.Error}}" }
+			}
+		},
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" },
+			{
+				"name": "test2", "url": "/home/test/repos/test2", "password": "2",
+				"prune": { "max_unused": "unlimited", "max_repack_size": "2G", "repack_cacheable_only": true }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, "5%", c.Repositories[0].Prune.MaxUnused)
+	assert.Equal(t, 168*time.Hour, c.Repositories[0].Prune.MinInterval.Duration)
+	assert.Equal(t, "notify.sh {{.Error}}", c.Repositories[0].Handler.PruneFailure)
+
+	assert.Equal(t, "unlimited", c.Repositories[1].Prune.MaxUnused)
+	assert.Equal(t, "2G", c.Repositories[1].Prune.MaxRepackSize)
+	assert.True(t, c.Repositories[1].Prune.RepackCacheableOnly)
+	assert.Equal(t, 168*time.Hour, c.Repositories[1].Prune.MinInterval.Duration)
+}
+
+func TestLoadConfigWithInvalidPruneShouldFail(t *testing.T) {
+	for _, prune := range []string{
+		`{ "max_unused": "5 percent" }`,
+		`{ "max_unused": "%" }`,
+		`{ "max_repack_size": "2 GB" }`,
+		`{ "min_interval": "-1h" }`,
+	} {
+		reader := strings.NewReader(`{
+			"repositories": [
+				{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "prune": ` + prune + ` }
+			]
+		}`)
+
+		_, err := LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, prune)
+	}
+}
+
+func TestLoadConfigWithForgetGuard(t *testing.T) {
+	reader := strings.NewReader(`{
+		"defaults": {
+			"repositories": {
+				"forget_guard": { "max_remove_percentage": 50, "min_age": "168h" }
+			}
+		},
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1" },
+			{
+				"name": "test2", "url": "/home/test/repos/test2", "password": "2",
+				"forget_guard": { "max_remove_count": 10, "min_age": "24h" }
+			}
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+
+	assert.Equal(t, uint(50), c.Repositories[0].ForgetGuard.MaxRemovePercentage)
+	assert.Equal(t, uint(0), c.Repositories[0].ForgetGuard.MaxRemoveCount)
+	assert.Equal(t, 168*time.Hour, c.Repositories[0].ForgetGuard.MinAge.Duration)
+
+	assert.Equal(t, uint(50), c.Repositories[1].ForgetGuard.MaxRemovePercentage)
+	assert.Equal(t, uint(10), c.Repositories[1].ForgetGuard.MaxRemoveCount)
+	assert.Equal(t, 24*time.Hour, c.Repositories[1].ForgetGuard.MinAge.Duration)
+}
+
+func TestLoadConfigWithInvalidForgetGuardShouldFail(t *testing.T) {
+	for _, guard := range []string{
+		`{ "max_remove_percentage": 101 }`,
+		`{ "min_age": "-1h" }`,
+	} {
+		reader := strings.NewReader(`{
+			"repositories": [
+				{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "forget_guard": ` + guard + ` }
+			]
+		}`)
+
+		_, err := LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, guard)
+	}
+}
+
+func TestLoadConfigWithReadDataSize(t *testing.T) {
+	reader := strings.NewReader(`{
+		"repositories": [
+			{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "check": { "read_data_size": "2G" } }
+		]
+	}`)
+
+	c, error := LoadFromReader(reader)
+	assert.True(t, error == nil)
+	assert.Equal(t, "2G", c.Repositories[0].Check.ReadDataSize)
+
+	for _, check := range []string{
+		`{ "read_data_size": "2 GB" }`,
+		`{ "read_data_size": "0" }`,
+		`{ "read_data_size": "2G", "read_data_percentage": 5 }`,
+	} {
+		reader := strings.NewReader(`{
+			"repositories": [
+				{ "name": "test1", "url": "/home/test/repos/test1", "password": "1", "check": ` + check + ` }
+			]
+		}`)
+
+		_, err := LoadFromReader(reader)
+		assert.IsType(t, ValidationError{}, err, check)
+	}
+}
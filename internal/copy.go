@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ErrCopyFailed is returned if a backup succeeded but copying its snapshot to
+// one of the copy_to repositories failed.
+var ErrCopyFailed = errors.New("copy failed")
+
+// dryRunSnapshotID stands in for the ID of the new snapshot in dry-run mode.
+const dryRunSnapshotID = "<snapshot>"
+
+// prepareCopyCommand prepares the restic command copy or init against the
+// target repository which reads from the source repository given by
+// RESTIC_FROM_REPOSITORY. The URL is passed in the environment as it might
+// contain credentials.
+func (r Restic) prepareCopyCommand(
+	command string, source Repository, target Repository, additionalEnvironment map[string]string,
+) *exec.Cmd {
+	// restic reads the credentials of both repositories from the same
+	// environment, only the settings meant for the source repository are
+	// taken from its environment
+	environment := make(map[string]string)
+	for key, value := range source.Environment {
+		if strings.HasPrefix(key, "RESTIC_FROM_") {
+			environment[key] = value
+		}
+	}
+
+	environment = combineMaps(environment, combineMaps(target.Environment, additionalEnvironment))
+	environment["RESTIC_FROM_REPOSITORY"] = source.URL
+	environment["RESTIC_FROM_PASSWORD"] = source.Password
+
+	cmd := r.prepareResticCommand(target, environment)
+	cmd.Args = append(cmd.Args, command)
+
+	return cmd
+}
+
+// copySnapshots copies the snapshots of a backup from the repository it has
+// just been saved to to each of the copy_to repositories.
+func (r Restic) copySnapshots(
+	backup Backup, source Repository, targets []Repository, summary *BackupSummary,
+) error {
+
+	var snapshotIDs []string
+
+	if r.dryRun {
+		snapshotIDs = []string{dryRunSnapshotID}
+	} else if summary != nil && summary.SnapshotID != "" {
+		snapshotIDs = strings.Split(summary.SnapshotID, ",")
+	}
+
+	if len(snapshotIDs) == 0 {
+		r.logStep("no new snapshot of backup [%s] to copy", backup.Name)
+		return nil
+	}
+
+	var err error
+	failed := 0
+
+	for _, target := range targets {
+		if r.isInterrupted() {
+			if err == nil {
+				err = fmt.Errorf("%w before copying to repository [%s]", ErrInterrupted, target.Name)
+			}
+			break
+		}
+
+		target = backup.overrideRepository(target)
+
+		if copyErr := r.copySnapshot(backup, source, target, snapshotIDs); copyErr != nil {
+			failed++
+			if err == nil {
+				err = fmt.Errorf("%w to repository [%s]: %s", ErrCopyFailed, target.Name, copyErr)
+			}
+		}
+	}
+
+	if failed > 1 {
+		err = fmt.Errorf("%w (%d of %d copies failed)", err, failed, len(targets))
+	}
+
+	return err
+}
+
+// copySnapshot copies the given snapshots to a single target repository and
+// runs the copy handlers of the backup.
+func (r Restic) copySnapshot(
+	backup Backup, source Repository, target Repository, snapshotIDs []string,
+) error {
+
+	environment := combineMaps(target.Environment, backup.Environment)
+
+	err := r.IsRepositoryAvailable(target)
+
+	if err == nil {
+		if err = r.prepareLocks(target); err != nil {
+			r.dumpUnlockError(target, err)
+		}
+	}
+
+	if err == nil {
+		cmd := r.prepareCopyCommand("copy", source, target, backup.Environment)
+		cmd.Args = append(cmd.Args, snapshotIDs...)
+
+		r.streamOutput(cmd)
+
+		err = r.runRestic(
+			fmt.Sprintf("copy backup [%s] from repository [%s] to [%s]", backup.Name, source.Name, target.Name),
+			cmd,
+		)
+	}
+
+	args := newHandlerArgs(&backup, &target)
+
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to copy backup [%s] to repository [%s]: %s\n",
+			backup.Name, target.Name, err,
+		)
+		r.runHandler(backup.Handler.CopyFailure, "copy_failure", environment, args.withError(err))
+	} else {
+		r.runHandler(backup.Handler.CopySuccess, "copy_success", environment, args)
+	}
+
+	return err
+}
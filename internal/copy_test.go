@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareCopyCommand(t *testing.T) {
+	r := NewRestic("restic", ResticOptions{})
+
+	source := Repository{
+		Name: "local", URL: "/repos/local", Password: "secret1",
+		Environment: map[string]string{
+			"AWS_ACCESS_KEY_ID": "source", "SOURCE_ONLY": "1", "RESTIC_FROM_KEY_HINT": "key",
+		},
+	}
+	target := Repository{
+		Name: "cloud", URL: "s3:s3.amazonaws.com/test", Password: "secret2",
+		Environment: map[string]string{"AWS_ACCESS_KEY_ID": "target"},
+	}
+	target.LimitUpload = 100
+
+	cmd := r.prepareCopyCommand("copy", source, target, map[string]string{"BACKUP": "1"})
+
+	assert.Equal(t, []string{"restic", "--limit-upload", "100", "copy"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "RESTIC_REPOSITORY=s3:s3.amazonaws.com/test")
+	assert.Contains(t, cmd.Env, "RESTIC_PASSWORD=secret2")
+	assert.Contains(t, cmd.Env, "RESTIC_FROM_REPOSITORY=/repos/local")
+	assert.Contains(t, cmd.Env, "RESTIC_FROM_PASSWORD=secret1")
+	assert.Contains(t, cmd.Env, "RESTIC_FROM_KEY_HINT=key")
+	assert.Contains(t, cmd.Env, "AWS_ACCESS_KEY_ID=target")
+	assert.NotContains(t, cmd.Env, "AWS_ACCESS_KEY_ID=source")
+	assert.NotContains(t, cmd.Env, "SOURCE_ONLY=1")
+	assert.Contains(t, cmd.Env, "BACKUP=1")
+}
+
+func TestCopySnapshotsWithoutSnapshotDoesNothing(t *testing.T) {
+	r := NewRestic("/nonexistent/restic", ResticOptions{})
+
+	targets := []Repository{{Name: "cloud"}}
+
+	assert.NoError(t, r.copySnapshots(Backup{Name: "laptop"}, Repository{Name: "local"}, targets, nil))
+	assert.NoError(t, r.copySnapshots(Backup{Name: "laptop"}, Repository{Name: "local"}, targets, &BackupSummary{}))
+}
+
+func TestCopySnapshotsFailure(t *testing.T) {
+	r := NewRestic("/nonexistent/restic", ResticOptions{})
+
+	targets := []Repository{{Name: "nas"}, {Name: "cloud"}}
+	summary := &BackupSummary{SnapshotID: "1234abcd,5678ef01"}
+
+	err := r.copySnapshots(Backup{Name: "laptop"}, Repository{Name: "local"}, targets, summary)
+	assert.True(t, errors.Is(err, ErrCopyFailed))
+	assert.Contains(t, err.Error(), "[nas]")
+	assert.Contains(t, err.Error(), "2 of 2 copies failed")
+}
+
+func TestCopySnapshotsInterrupted(t *testing.T) {
+	r := NewRestic("/nonexistent/restic", ResticOptions{Interrupted: func() bool { return true }})
+
+	summary := &BackupSummary{SnapshotID: "1234abcd"}
+	err := r.copySnapshots(Backup{Name: "laptop"}, Repository{Name: "local"}, []Repository{{Name: "cloud"}}, summary)
+	assert.True(t, errors.Is(err, ErrInterrupted))
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// trailing lines written by the dump commands after a complete dump
+const (
+	postgresDumpCompleteMarker = "-- PostgreSQL database dump complete"
+	mysqlDumpCompleteMarker    = "-- Dump completed"
+	sqliteDumpCompleteMarker   = "COMMIT;"
+)
+
+// stdinSource returns the stdin source dumping the database. Passwords are
+// passed in the environment of the dump command instead of its arguments.
+func (d Database) stdinSource() StdinSource {
+
+	var args []string
+	var marker string
+	environment := make(map[string]string)
+
+	switch d.Type {
+	case DatabasePostgres:
+		args = []string{"pg_dump", "--format=plain", "--no-password"}
+		if d.Host != "" {
+			args = append(args, "--host", d.Host)
+		}
+		if d.Port != 0 {
+			args = append(args, "--port", strconv.Itoa(d.Port))
+		}
+		if d.User != "" {
+			args = append(args, "--username", d.User)
+		}
+		if d.Password != "" {
+			environment["PGPASSWORD"] = d.Password
+		}
+		args = append(args, d.Options...)
+		args = append(args, "--", d.Name)
+		marker = postgresDumpCompleteMarker
+
+	case DatabaseMySQL:
+		args = []string{"mysqldump", "--single-transaction", "--routines", "--triggers", "--events"}
+		if d.Host != "" {
+			args = append(args, "--host", d.Host)
+		}
+		if d.Port != 0 {
+			args = append(args, "--port", strconv.Itoa(d.Port))
+		}
+		if d.User != "" {
+			args = append(args, "--user", d.User)
+		}
+		if d.Password != "" {
+			environment["MYSQL_PWD"] = d.Password
+		}
+		args = append(args, d.Options...)
+		args = append(args, "--", d.Name)
+		marker = mysqlDumpCompleteMarker
+
+	case DatabaseSQLite:
+		// .dump reads the whole database within one transaction
+		args = []string{"sqlite3", "-readonly"}
+		args = append(args, d.Options...)
+		args = append(args, d.Path, ".dump")
+		marker = sqliteDumpCompleteMarker
+	}
+
+	source := StdinSource{
+		Command:     formatCommandLine(args),
+		Filename:    d.Filename,
+		Validation:  d.Validation,
+		Environment: environment,
+	}
+
+	if source.Filename == "" {
+		source.Filename = d.defaultFilename()
+	}
+
+	if !source.Validation.isEnabled() {
+		source.Validation = StdinValidation{TrailingMarker: marker}
+	}
+
+	return source
+}
+
+func (d Database) defaultFilename() string {
+	name := d.Name
+	if d.Type == DatabaseSQLite {
+		name = strings.TrimSuffix(filepath.Base(d.Path), filepath.Ext(d.Path))
+	}
+	return name + ".sql"
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseStdinSource(t *testing.T) {
+	source := Database{
+		Type:     DatabasePostgres,
+		Name:     "shop",
+		Host:     "db.example.com",
+		Port:     5433,
+		User:     "backup",
+		Password: "secret",
+	}.stdinSource()
+
+	assert.Equal(
+		t, "pg_dump --format=plain --no-password --host db.example.com --port 5433 --username backup -- shop",
+		source.Command,
+	)
+	assert.Equal(t, "shop.sql", source.Filename)
+	assert.Equal(t, map[string]string{"PGPASSWORD": "secret"}, source.Environment)
+	assert.Equal(t, "-- PostgreSQL database dump complete", source.Validation.TrailingMarker)
+
+	source = Database{
+		Type:       DatabaseMySQL,
+		Name:       "wiki",
+		Password:   "secret",
+		Options:    []string{"--skip-lock-tables"},
+		Filename:   "wiki-dump.sql",
+		Validation: StdinValidation{MinBytes: 1024},
+	}.stdinSource()
+
+	assert.Equal(
+		t, "mysqldump --single-transaction --routines --triggers --events --skip-lock-tables -- wiki",
+		source.Command,
+	)
+	assert.Equal(t, "wiki-dump.sql", source.Filename)
+	assert.Equal(t, map[string]string{"MYSQL_PWD": "secret"}, source.Environment)
+	assert.Equal(t, StdinValidation{MinBytes: 1024}, source.Validation)
+
+	source = Database{Type: DatabaseSQLite, Path: "/var/lib/app data/app.db"}.stdinSource()
+
+	assert.Equal(t, "sqlite3 -readonly '/var/lib/app data/app.db' .dump", source.Command)
+	assert.Equal(t, "app.sql", source.Filename)
+}
+
+func TestDatabaseSQLiteDump(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 not available")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.db")
+	err = exec.Command("sqlite3", path, "CREATE TABLE t (v TEXT); INSERT INTO t VALUES ('rester');").Run()
+	assert.Nil(t, err)
+
+	source := Database{Type: DatabaseSQLite, Path: path}.stdinSource()
+
+	cmd, err := prepareShellCommand(source.Command, source.Environment, Resources{})
+	assert.Nil(t, err)
+
+	validator, err := newStdinValidator(source.Validation, nil, Resources{})
+	assert.Nil(t, err)
+
+	cmd.Stdout = validator
+	assert.Nil(t, cmd.Run())
+	assert.Nil(t, validator.Finish())
+	assert.True(t, strings.Contains(string(validator.tail), "INSERT INTO t VALUES('rester');"))
+
+	source = Database{Type: DatabaseSQLite, Path: filepath.Join(dir, "missing", "app.db")}.stdinSource()
+
+	cmd, err = prepareShellCommand(source.Command, source.Environment, Resources{})
+	assert.Nil(t, err)
+	assert.NotNil(t, cmd.Run())
+}
@@ -0,0 +1,721 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrForgetAborted is returned if forget would violate a safety guard.
+var ErrForgetAborted = errors.New("forget aborted")
+
+// groupByFields are the fields restic's forget is able to group snapshots by.
+var groupByFields = []string{"host", "paths", "tags"}
+
+// validScopeByFields are the filters selecting the snapshots of a backup when
+// forgetting with its own policy.
+var validScopeByFields = []string{"host", "tag", "paths"}
+
+// defaultScopeBy selects the snapshots of a backup by host and backup tag.
+const defaultScopeBy = "host,tag"
+
+func (p Policy) isEmpty() bool {
+	return p.KeepLast == 0 && p.KeepHourly == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 &&
+		p.KeepMonthly == 0 && p.KeepYearly == 0 && p.KeepWithin == "" && len(p.KeepTags) == 0
+}
+
+// args returns the arguments passed to restic's forget for the policy.
+func (p Policy) args() []string {
+	var args []string
+
+	if p.KeepLast > 0 {
+		args = append(args, "--keep-last", fmt.Sprint(p.KeepLast))
+	}
+
+	if p.KeepHourly > 0 {
+		args = append(args, "--keep-hourly", fmt.Sprint(p.KeepHourly))
+	}
+
+	if p.KeepDaily > 0 {
+		args = append(args, "--keep-daily", fmt.Sprint(p.KeepDaily))
+	}
+
+	if p.KeepWeekly > 0 {
+		args = append(args, "--keep-weekly", fmt.Sprint(p.KeepWeekly))
+	}
+
+	if p.KeepMonthly > 0 {
+		args = append(args, "--keep-monthly", fmt.Sprint(p.KeepMonthly))
+	}
+
+	if p.KeepYearly > 0 {
+		args = append(args, "--keep-yearly", fmt.Sprint(p.KeepYearly))
+	}
+
+	if p.KeepWithin != "" {
+		args = append(args, "--keep-within", p.KeepWithin)
+	}
+
+	for _, tag := range p.KeepTags {
+		args = append(args, "--keep-tag", tag)
+	}
+
+	if p.GroupBy != "" {
+		args = append(args, "--group-by", p.GroupBy)
+	}
+
+	return args
+}
+
+// isValidGroupBy reports whether groupBy is a comma separated list of the
+// fields restic is able to group by. An empty string uses restic's default.
+func isValidGroupBy(groupBy string) bool {
+	if groupBy == "" {
+		return true
+	}
+
+	for _, field := range strings.Split(groupBy, ",") {
+		if !Contains(groupByFields, strings.TrimSpace(field)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isValidScopeBy reports whether scopeBy is a comma separated list of the
+// fields selecting the snapshots of a backup. An empty string selects by
+// host and backup tag.
+func isValidScopeBy(scopeBy string) bool {
+	for _, field := range scopeByFields(scopeBy) {
+		if !Contains(validScopeByFields, field) {
+			return false
+		}
+	}
+	return true
+}
+
+// scopeByFields returns the fields of scopeBy falling back to the default.
+func scopeByFields(scopeBy string) []string {
+	if scopeBy == "" {
+		scopeBy = defaultScopeBy
+	}
+
+	var fields []string
+	for _, field := range strings.Split(scopeBy, ",") {
+		fields = append(fields, strings.TrimSpace(field))
+	}
+	return fields
+}
+
+// backupsInRepository returns the backups with snapshots in the repository
+// either by backing up to it or by copying to it.
+func backupsInRepository(backups []Backup, repositoryName string) []Backup {
+	var result []Backup
+
+	for _, backup := range backups {
+		if Contains(backup.GetTargetRepositories(), repositoryName) {
+			result = append(result, backup)
+		}
+	}
+
+	return result
+}
+
+// hasBackupPolicy reports whether any of the backups has its own policy.
+func hasBackupPolicy(backups []Backup) bool {
+	for _, backup := range backups {
+		if !backup.Policy.isEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// forgetScope is a single run of restic's forget covering either the whole
+// repository or the snapshots of a single backup selected by host, tag and
+// paths. An unclaimed scope covers the snapshots none of the other scopes
+// select. As restic is unable to select them at once forget runs for each of
+// their hosts, paths and tags instead.
+type forgetScope struct {
+	backup      string
+	description string
+	environment map[string]string
+	policy      Policy
+	host        string
+	tag         string
+	paths       []string
+	unclaimed   bool
+}
+
+// args returns the arguments passed to restic's forget for the scope.
+func (s forgetScope) args() []string {
+	var args []string
+
+	if s.host != "" {
+		args = append(args, "--host", s.host)
+	}
+
+	if s.tag != "" {
+		args = append(args, "--tag", s.tag)
+	}
+
+	for _, path := range s.paths {
+		args = append(args, "--path", path)
+	}
+
+	return append(args, s.policy.args()...)
+}
+
+// selects reports whether restic's forget selects the snapshot using the
+// filters of the scope.
+func (s forgetScope) selects(snapshot Snapshot) bool {
+	if s.host != "" && snapshot.Hostname != s.host {
+		return false
+	}
+
+	if s.tag != "" && !Contains(snapshot.Tags, s.tag) {
+		return false
+	}
+
+	for _, path := range s.paths {
+		if !Contains(snapshot.Paths, path) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// unclaimedSnapshots returns the snapshots selected by none of the scopes.
+func unclaimedSnapshots(scopes []forgetScope, snapshots []Snapshot) []Snapshot {
+	var result []Snapshot
+
+	for _, snapshot := range snapshots {
+		claimed := false
+		for _, scope := range scopes {
+			if !scope.unclaimed && scope.selects(snapshot) {
+				claimed = true
+				break
+			}
+		}
+
+		if !claimed {
+			result = append(result, snapshot)
+		}
+	}
+
+	return result
+}
+
+// ForgetGroup is a group of snapshots as printed by "restic forget --json".
+type ForgetGroup struct {
+	Host    string         `json:"host"`
+	Paths   []string       `json:"paths"`
+	Tags    []string       `json:"tags"`
+	Keep    []Snapshot     `json:"keep"`
+	Remove  []Snapshot     `json:"remove"`
+	Reasons []ForgetReason `json:"reasons"`
+}
+
+// ForgetReason lists the rules of the policy which keep a snapshot e.g.
+// "daily snapshot".
+type ForgetReason struct {
+	Snapshot Snapshot `json:"snapshot"`
+	Matches  []string `json:"matches"`
+}
+
+// KeepReasons returns the rules of the policy which keep the snapshot.
+func (g ForgetGroup) KeepReasons(snapshot Snapshot) []string {
+	for _, reason := range g.Reasons {
+		if reason.Snapshot.ID == snapshot.ID {
+			return reason.Matches
+		}
+	}
+	return nil
+}
+
+// ForgetPreview is what forget would do with the snapshots of a backup or of
+// the whole repository if Backup is empty.
+type ForgetPreview struct {
+	Backup string
+	Groups []ForgetGroup
+}
+
+// forgetScopes returns the runs of forget needed for the repository. If any
+// backup stored in the repository has its own policy, forget runs for each
+// backup scoped by its scope_by fields. The policy of the repository then
+// applies to all snapshots not belonging to any backup. Otherwise the policy
+// of the repository is applied to all snapshots at once.
+func forgetScopes(repository Repository, backups []Backup) ([]forgetScope, error) {
+
+	if !hasBackupPolicy(backups) {
+		if repository.Policy.isEmpty() {
+			return nil, nil
+		}
+
+		return []forgetScope{{
+			description: fmt.Sprintf("repository [%s]", repository.Name),
+			environment: make(map[string]string),
+			policy:      repository.Policy,
+		}}, nil
+	}
+
+	var scopes []forgetScope
+
+	for _, backup := range backups {
+		policy := backup.GetPolicy(repository)
+
+		scope := forgetScope{
+			backup:      backup.Name,
+			description: fmt.Sprintf("backup [%s] in repository [%s]", backup.Name, repository.Name),
+			environment: backup.Environment,
+			policy:      policy,
+		}
+
+		for _, field := range scopeByFields(policy.ScopeBy) {
+			switch field {
+			case "host":
+				host, err := backup.GetHost()
+				if err != nil {
+					return nil, err
+				}
+				scope.host = host
+			case "tag":
+				scope.tag = backupTag(backup.Name)
+			case "paths":
+				scope.paths = backup.snapshotPaths()
+			}
+		}
+
+		// backups without any policy still claim their snapshots
+		scopes = append(scopes, scope)
+	}
+
+	if !repository.Policy.isEmpty() {
+		scopes = append(scopes, forgetScope{
+			description: fmt.Sprintf("snapshots of no backup in repository [%s]", repository.Name),
+			environment: make(map[string]string),
+			policy:      repository.Policy,
+			unclaimed:   true,
+		})
+	}
+
+	for _, scope := range scopes {
+		if !scope.policy.isEmpty() {
+			return scopes, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RunForget forgets snapshots according to the policies. The snapshots to
+// remove are determined by a dry run first and checked against the forget
+// guard of the repository. Unless forced, nothing is removed if a guard is
+// violated. Unused data is removed by RunPrune.
+func (r Restic) RunForget(repository Repository, backups []Backup, force bool) error {
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		r.runHandlerForgetFailure(repository, err)
+		return err
+	}
+
+	backups = backupsInRepository(backups, repository.Name)
+
+	scopes, err := forgetScopes(repository, backups)
+
+	if err == nil && len(scopes) == 0 {
+		err = fmt.Errorf("%w: policy is empty", ErrForgetAborted)
+	}
+
+	if err == nil {
+		err = r.forget(repository, backups, scopes, force)
+	}
+
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to forget for repository [%s]: %s\n",
+			repository.Name,
+			err,
+		)
+		r.runHandlerForgetFailure(repository, err)
+		return err
+	}
+
+	r.runHandler(repository.Handler.ForgetSuccess, "forget_success", repository.Environment, newHandlerArgs(nil, &repository))
+
+	return nil
+}
+
+func (r Restic) forget(repository Repository, backups []Backup, scopes []forgetScope, force bool) error {
+
+	snapshots, err := r.listSnapshots(repository, make(map[string]string))
+	if err != nil {
+		return err
+	}
+
+	var remove []Snapshot
+	removeIDs := make(map[string]bool)
+
+	for _, scope := range scopes {
+		if r.isInterrupted() {
+			return fmt.Errorf("%w: interrupted", ErrForgetAborted)
+		}
+
+		if scope.policy.isEmpty() {
+			continue
+		}
+
+		groups, err := r.forgetGroups(repository, scopes, scope, snapshots)
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			for _, snapshot := range group.Remove {
+				if !removeIDs[snapshot.ID] {
+					removeIDs[snapshot.ID] = true
+					remove = append(remove, snapshot)
+				}
+			}
+		}
+	}
+
+	if r.dryRun {
+		return nil
+	}
+
+	remove = r.protectRecentSnapshots(repository, remove, time.Now())
+
+	if len(remove) == 0 {
+		r.logStep("no snapshots to forget in repository [%s]", repository.Name)
+		return nil
+	}
+
+	if violation := checkForgetGuard(repository, backups, snapshots, remove); violation != "" {
+		if !force {
+			return fmt.Errorf("%w: %s", ErrForgetAborted, violation)
+		}
+		fmt.Fprintf(os.Stderr, "Forgetting in repository [%s] although %s\n", repository.Name, violation)
+	}
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "forget")
+
+	for _, snapshot := range remove {
+		cmd.Args = append(cmd.Args, snapshot.ID)
+	}
+
+	r.streamOutput(cmd)
+
+	return r.runRestic(
+		fmt.Sprintf("forget %d of %d snapshots in repository [%s]", len(remove), len(snapshots), repository.Name),
+		cmd,
+	)
+}
+
+// PreviewForget returns the snapshots forget would keep and remove without
+// removing any of them. Snapshots protected by the min_age of the forget
+// guard are shown as kept. The repository is neither locked nor are stale
+// locks removed.
+func (r Restic) PreviewForget(repository Repository, backups []Backup) ([]ForgetPreview, error) {
+
+	r.noLock = true
+
+	scopes, err := forgetScopes(repository, backupsInRepository(backups, repository.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("policy of repository [%s] is empty", repository.Name)
+	}
+
+	snapshots, err := r.listSnapshots(repository, make(map[string]string))
+	if err != nil {
+		return nil, err
+	}
+
+	var previews []ForgetPreview
+	now := time.Now()
+
+	for _, scope := range scopes {
+		if scope.policy.isEmpty() {
+			continue
+		}
+
+		groups, err := r.forgetGroups(repository, scopes, scope, snapshots)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range groups {
+			groups[i] = protectRecentGroupSnapshots(repository, groups[i], now)
+		}
+
+		if len(groups) > 0 {
+			previews = append(previews, ForgetPreview{Backup: scope.backup, Groups: groups})
+		}
+	}
+
+	return previews, nil
+}
+
+// protectRecentGroupSnapshots moves snapshots younger than the min_age of the
+// forget guard from the snapshots to remove to the ones to keep.
+func protectRecentGroupSnapshots(repository Repository, group ForgetGroup, now time.Time) ForgetGroup {
+
+	var remove []Snapshot
+
+	for _, snapshot := range group.Remove {
+		if isProtectedSnapshot(repository, snapshot, now) {
+			group.Keep = append(group.Keep, snapshot)
+			group.Reasons = append(group.Reasons, ForgetReason{Snapshot: snapshot, Matches: []string{"min_age"}})
+		} else {
+			remove = append(remove, snapshot)
+		}
+	}
+
+	group.Remove = remove
+
+	return group
+}
+
+// forgetGroups returns the snapshots the scope keeps and removes.
+func (r Restic) forgetGroups(
+	repository Repository, scopes []forgetScope, scope forgetScope, snapshots []Snapshot,
+) ([]ForgetGroup, error) {
+
+	if !scope.unclaimed {
+		return r.forgetDryRun(repository, scope)
+	}
+
+	unclaimed := unclaimedSnapshots(scopes, snapshots)
+
+	unclaimedIDs := make(map[string]bool)
+	for _, snapshot := range unclaimed {
+		unclaimedIDs[snapshot.ID] = true
+	}
+
+	var result []ForgetGroup
+	seen := make(map[string]bool)
+
+	for _, groupScope := range unclaimedScopes(repository, scope, unclaimed) {
+		groups, err := r.forgetDryRun(repository, groupScope)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, group := range groups {
+			key := groupKey(group)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			if !onlySnapshotsOf(group, unclaimedIDs) {
+				fmt.Fprintf(
+					os.Stderr, "Keeping %d snapshots of no backup of host %s in repository [%s] as restic groups them with snapshots of backups\n",
+					countSnapshotsOf(group, unclaimedIDs), group.Host, repository.Name,
+				)
+				continue
+			}
+
+			result = append(result, group)
+		}
+	}
+
+	return result, nil
+}
+
+// unclaimedScopes returns a scope selecting each set of unclaimed snapshots
+// sharing host, paths and tags. Like any other scope restic decides which of
+// their snapshots to remove.
+func unclaimedScopes(repository Repository, scope forgetScope, unclaimed []Snapshot) []forgetScope {
+
+	var result []forgetScope
+	seen := make(map[string]bool)
+
+	for _, snapshot := range unclaimed {
+		paths := append([]string{}, snapshot.Paths...)
+		sort.Strings(paths)
+		tags := append([]string{}, snapshot.Tags...)
+		sort.Strings(tags)
+
+		key := strings.Join([]string{snapshot.Hostname, strings.Join(paths, "\x00"), strings.Join(tags, "\x00")}, "\x01")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		result = append(result, forgetScope{
+			description: fmt.Sprintf(
+				"snapshots of no backup of host %s with paths %s in repository [%s]",
+				snapshot.Hostname, strings.Join(paths, ", "), repository.Name,
+			),
+			environment: scope.environment,
+			policy:      scope.policy,
+			host:        snapshot.Hostname,
+			tag:         strings.Join(tags, ","),
+			paths:       paths,
+			unclaimed:   true,
+		})
+	}
+
+	return result
+}
+
+// groupKey identifies a group of restic's forget by its snapshots.
+func groupKey(group ForgetGroup) string {
+	var ids []string
+	for _, snapshot := range append(append([]Snapshot{}, group.Keep...), group.Remove...) {
+		ids = append(ids, snapshot.ID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// onlySnapshotsOf reports whether all snapshots of the group are in ids.
+func onlySnapshotsOf(group ForgetGroup, ids map[string]bool) bool {
+	return countSnapshotsOf(group, ids) == len(group.Keep)+len(group.Remove)
+}
+
+// countSnapshotsOf returns the number of snapshots of the group in ids.
+func countSnapshotsOf(group ForgetGroup, ids map[string]bool) int {
+	count := 0
+	for _, snapshot := range append(append([]Snapshot{}, group.Keep...), group.Remove...) {
+		if ids[snapshot.ID] {
+			count++
+		}
+	}
+	return count
+}
+
+// forgetDryRun returns the snapshots restic would keep and remove. In dry-run
+// mode the command is only printed and nil is returned.
+func (r Restic) forgetDryRun(repository Repository, scope forgetScope) ([]ForgetGroup, error) {
+
+	cmd := r.prepareResticCommand(repository, scope.environment)
+	cmd.Args = append(cmd.Args, "forget", "--dry-run", "--json")
+	cmd.Args = append(cmd.Args, scope.args()...)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+
+	err := r.runRestic(fmt.Sprintf("determine snapshots to forget for %s", scope.description), cmd)
+	if err != nil || r.dryRun {
+		return nil, err
+	}
+
+	var groups []ForgetGroup
+
+	if err := json.Unmarshal(output.Bytes(), &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse output of forget: %s", err)
+	}
+
+	return groups, nil
+}
+
+// protectRecentSnapshots drops snapshots younger than the min_age of the
+// forget guard from the snapshots to remove.
+func (r Restic) protectRecentSnapshots(repository Repository, remove []Snapshot, now time.Time) []Snapshot {
+
+	var result []Snapshot
+
+	for _, snapshot := range remove {
+		if isProtectedSnapshot(repository, snapshot, now) {
+			r.logStep(
+				"keep snapshot %s younger than min_age %s",
+				shortID(snapshot.ID), repository.ForgetGuard.MinAge.Duration,
+			)
+			continue
+		}
+		result = append(result, snapshot)
+	}
+
+	return result
+}
+
+// isProtectedSnapshot reports whether the snapshot is younger than the min_age
+// of the forget guard.
+func isProtectedSnapshot(repository Repository, snapshot Snapshot, now time.Time) bool {
+	minAge := repository.ForgetGuard.MinAge.Duration
+	return minAge > 0 && now.Sub(snapshot.Time) < minAge
+}
+
+// checkForgetGuard returns why removing the snapshots violates the forget
+// guard of the repository or an empty string.
+func checkForgetGuard(repository Repository, backups []Backup, snapshots []Snapshot, remove []Snapshot) string {
+
+	guard := repository.ForgetGuard
+
+	if guard.MaxRemoveCount > 0 && uint(len(remove)) > guard.MaxRemoveCount {
+		return fmt.Sprintf(
+			"it would remove %d snapshots, more than max_remove_count %d",
+			len(remove), guard.MaxRemoveCount,
+		)
+	}
+
+	if guard.MaxRemovePercentage > 0 && len(snapshots) > 0 &&
+		uint(len(remove))*100 > guard.MaxRemovePercentage*uint(len(snapshots)) {
+		return fmt.Sprintf(
+			"it would remove %d of %d snapshots, more than max_remove_percentage %d%%",
+			len(remove), len(snapshots), guard.MaxRemovePercentage,
+		)
+	}
+
+	if backup := removesLastSnapshot(backups, snapshots, remove); backup != "" {
+		return fmt.Sprintf("it would remove the last snapshot of backup [%s]", backup)
+	}
+
+	return ""
+}
+
+// removesLastSnapshot returns the name of a backup whose last snapshot or the
+// last snapshot of one of its stdin sources would be removed.
+func removesLastSnapshot(backups []Backup, snapshots []Snapshot, remove []Snapshot) string {
+
+	removeIDs := make(map[string]bool)
+	for _, snapshot := range remove {
+		removeIDs[snapshot.ID] = true
+	}
+
+	var remaining []Snapshot
+	for _, snapshot := range snapshots {
+		if !removeIDs[snapshot.ID] {
+			remaining = append(remaining, snapshot)
+		}
+	}
+
+	for _, backup := range backups {
+		hostname, err := backup.GetHost()
+		if err != nil {
+			continue
+		}
+
+		sources := backup.GetStdinSources()
+
+		if len(sources) == 0 {
+			if latestDataSnapshot(backup, snapshots, hostname) != nil &&
+				latestDataSnapshot(backup, remaining, hostname) == nil {
+				return backup.Name
+			}
+		}
+
+		for _, source := range sources {
+			if latestSourceSnapshot(backup, source, snapshots, hostname) != nil &&
+				latestSourceSnapshot(backup, source, remaining, hostname) == nil {
+				return backup.Name
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,320 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	jsonutil "github.com/vrischmann/jsonutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyArgs(t *testing.T) {
+	assert.Empty(t, Policy{}.args())
+	assert.True(t, Policy{}.isEmpty())
+	assert.True(t, Policy{GroupBy: "host"}.isEmpty())
+
+	policy := Policy{
+		KeepLast: 3, KeepDaily: 7, KeepWithin: "2d",
+		KeepTags: []string{"keep"}, GroupBy: "host,tags",
+	}
+
+	assert.False(t, policy.isEmpty())
+	assert.Equal(t, []string{
+		"--keep-last", "3", "--keep-daily", "7", "--keep-within", "2d",
+		"--keep-tag", "keep", "--group-by", "host,tags",
+	}, policy.args())
+}
+
+func TestIsValidGroupBy(t *testing.T) {
+	assert.True(t, isValidGroupBy(""))
+	assert.True(t, isValidGroupBy("host"))
+	assert.True(t, isValidGroupBy("host,paths,tags"))
+	assert.False(t, isValidGroupBy("hosts"))
+	assert.False(t, isValidGroupBy("host,"))
+}
+
+func TestBackupsInRepository(t *testing.T) {
+	backups := []Backup{
+		{Name: "home", Repositories: []string{"local"}},
+		{Name: "db", Repositories: []string{"local"}, CopyTo: []string{"cloud"}, Policy: Policy{KeepLast: 3}},
+		{Name: "etc", Repositories: []string{"cloud"}},
+	}
+
+	local := backupsInRepository(backups, "local")
+	assert.Len(t, local, 2)
+	assert.True(t, hasBackupPolicy(local))
+
+	cloud := backupsInRepository(backups, "cloud")
+	assert.Equal(t, "db", cloud[0].Name)
+	assert.Equal(t, "etc", cloud[1].Name)
+
+	assert.False(t, hasBackupPolicy(backupsInRepository(backups, "other")))
+	assert.False(t, hasBackupPolicy(backups[2:]))
+}
+
+func TestForgetScopes(t *testing.T) {
+	repository := Repository{Name: "local"}
+	repository.Policy = Policy{KeepDaily: 7}
+
+	scopes, err := forgetScopes(repository, []Backup{{Name: "home", Host: "box"}})
+	assert.NoError(t, err)
+	assert.Len(t, scopes, 1)
+	assert.Equal(t, []string{"--keep-daily", "7"}, scopes[0].args())
+
+	scopes, err = forgetScopes(repository, []Backup{
+		{Name: "home", Host: "box"},
+		{Name: "db", Host: "box", Policy: Policy{KeepLast: 3}},
+		{Name: "etc", Host: "box", Data: []string{"/etc"}, Policy: Policy{KeepLast: 5, ScopeBy: "paths"}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, scopes, 4)
+	assert.Equal(t, []string{"--host", "box", "--tag", "rester:home", "--keep-daily", "7"}, scopes[0].args())
+	assert.Equal(t, []string{"--host", "box", "--tag", "rester:db", "--keep-last", "3"}, scopes[1].args())
+	assert.Equal(t, []string{"--path", "/etc", "--keep-last", "5"}, scopes[2].args())
+	assert.True(t, scopes[3].unclaimed)
+	assert.Equal(t, Policy{KeepDaily: 7}, scopes[3].policy)
+
+	scopes, err = forgetScopes(Repository{Name: "local"}, []Backup{{Name: "home", Host: "box"}})
+	assert.NoError(t, err)
+	assert.Empty(t, scopes)
+}
+
+func TestUnclaimedSnapshots(t *testing.T) {
+	repository := Repository{Name: "local"}
+
+	scopes, err := forgetScopes(repository, []Backup{
+		{Name: "home", Host: "box", Policy: Policy{KeepLast: 3}},
+		{Name: "etc", Host: "box", Data: []string{"/etc"}, Policy: Policy{ScopeBy: "host,paths"}},
+	})
+	assert.NoError(t, err)
+
+	// backups without a policy of their own still claim their snapshots
+	assert.Len(t, scopes, 2)
+
+	snapshots := []Snapshot{
+		{ID: "a", Hostname: "box", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+		{ID: "b", Hostname: "box", Paths: []string{"/home"}},
+		{ID: "c", Hostname: "other", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+		{ID: "d", Hostname: "box", Paths: []string{"/etc"}},
+		{ID: "e", Hostname: "box", Paths: []string{"/etc", "/var"}},
+		{ID: "f", Hostname: "box", Paths: []string{"/var"}},
+	}
+
+	// like restic's --path a snapshot is selected if it includes all paths
+	assert.Equal(t, []string{"b", "c", "f"}, ids(unclaimedSnapshots(scopes, snapshots)))
+
+	repository.Policy = Policy{KeepLast: 1}
+	scopes, err = forgetScopes(repository, []Backup{{Name: "home", Host: "box", Policy: Policy{KeepLast: 3}}})
+	assert.NoError(t, err)
+	assert.Len(t, scopes, 2)
+	assert.True(t, scopes[1].unclaimed)
+}
+
+func TestUnclaimedScopes(t *testing.T) {
+	unclaimed := []Snapshot{
+		{ID: "b", Hostname: "box", Paths: []string{"/home"}},
+		{ID: "c", Hostname: "other", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+		{ID: "f", Hostname: "box", Paths: []string{"/var"}},
+		{ID: "g", Hostname: "box", Paths: []string{"/var"}},
+		{ID: "h", Hostname: "box", Paths: []string{"/var", "/opt"}, Tags: []string{"b", "a"}},
+	}
+
+	scope := forgetScope{policy: Policy{KeepLast: 1}, unclaimed: true}
+	scopes := unclaimedScopes(Repository{Name: "local"}, scope, unclaimed)
+
+	assert.Len(t, scopes, 4)
+	assert.Equal(t, []string{"--host", "box", "--path", "/home", "--keep-last", "1"}, scopes[0].args())
+	assert.Equal(t, []string{"--host", "other", "--tag", "rester:home", "--path", "/home", "--keep-last", "1"}, scopes[1].args())
+	assert.Equal(t, []string{"--host", "box", "--path", "/var", "--keep-last", "1"}, scopes[2].args())
+	assert.Equal(t, []string{"--host", "box", "--tag", "a,b", "--path", "/opt", "--path", "/var", "--keep-last", "1"}, scopes[3].args())
+}
+
+func TestForgetGroupsOfUnclaimedSnapshots(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake restic is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	argsLog := filepath.Join(dir, "args.log")
+	output := filepath.Join(dir, "output.json")
+
+	restic := filepath.Join(dir, "restic")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsLog + "\ncat " + output + "\n"
+	assert.Nil(t, ioutil.WriteFile(restic, []byte(script), 0755))
+
+	repository := Repository{Name: "local", URL: dir}
+	repository.Policy = Policy{KeepLast: 1}
+
+	scopes, err := forgetScopes(repository, []Backup{{Name: "home", Host: "box", Policy: Policy{KeepLast: 3}}})
+	assert.NoError(t, err)
+
+	snapshots := []Snapshot{
+		{ID: "a", Hostname: "box", Paths: []string{"/var"}, Tags: []string{"rester:home"}},
+		{ID: "b", Hostname: "box", Paths: []string{"/var"}},
+		{ID: "c", Hostname: "box", Paths: []string{"/var"}},
+	}
+
+	r := NewRestic(restic, ResticOptions{StateDirectory: dir})
+
+	// restic decides which of the unclaimed snapshots to remove
+	assert.Nil(t, ioutil.WriteFile(output, []byte(`[{
+		"host": "box", "paths": ["/var"],
+		"keep": [{"id": "c", "hostname": "box", "paths": ["/var"]}],
+		"remove": [{"id": "b", "hostname": "box", "paths": ["/var"]}]
+	}]`), 0644))
+
+	groups, err := r.forgetGroups(repository, scopes, scopes[1], snapshots)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 1)
+	assert.Equal(t, "b", groups[0].Remove[0].ID)
+
+	log, err := ioutil.ReadFile(argsLog)
+	assert.Nil(t, err)
+	assert.Contains(t, string(log), "forget --dry-run --json --host box --path /var --keep-last 1")
+
+	// groups mixing in snapshots of backups are kept
+	assert.Nil(t, ioutil.WriteFile(output, []byte(`[{
+		"host": "box", "paths": ["/var"],
+		"keep": [{"id": "a", "hostname": "box", "paths": ["/var"], "tags": ["rester:home"]}],
+		"remove": [{"id": "b", "hostname": "box", "paths": ["/var"]}, {"id": "c", "hostname": "box", "paths": ["/var"]}]
+	}]`), 0644))
+
+	groups, err = r.forgetGroups(repository, scopes, scopes[1], snapshots)
+	assert.NoError(t, err)
+	assert.Empty(t, groups)
+}
+
+func TestPreviewForgetWithoutLocks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake restic is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	argsLog := filepath.Join(dir, "args.log")
+
+	// a stale lock would be removed before running any other command
+	restic := filepath.Join(dir, "restic")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsLog + "\n" +
+		"case \"$*\" in\n" +
+		"*\"list locks\"*) echo 0123 ;;\n" +
+		"*\"cat lock\"*) echo '{\"time\": \"2001-01-01T00:00:00Z\", \"hostname\": \"other\"}' ;;\n" +
+		"*) echo '[]' ;;\n" +
+		"esac\n"
+	assert.Nil(t, ioutil.WriteFile(restic, []byte(script), 0755))
+
+	repository := Repository{Name: "local", URL: dir}
+	repository.Policy = Policy{KeepLast: 1}
+	repository.Lock = Lock{Strategy: LockStrategyStaleOnly}
+
+	r := NewRestic(restic, ResticOptions{StateDirectory: dir})
+
+	_, err = r.PreviewForget(repository, nil)
+	assert.NoError(t, err)
+
+	log, err := ioutil.ReadFile(argsLog)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(log), "unlock")
+
+	for _, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+		assert.Contains(t, line, "--no-lock")
+	}
+}
+
+func TestCheckForgetGuard(t *testing.T) {
+	now := time.Now()
+	backups := []Backup{{Name: "home", Host: "box", Data: []string{"/home"}}}
+
+	var snapshots []Snapshot
+	for i, id := range []string{"a", "b", "c", "d"} {
+		snapshots = append(snapshots, Snapshot{
+			ID: id, Time: now.Add(time.Duration(i-4) * time.Hour),
+			Hostname: "box", Paths: []string{"/home"}, Tags: []string{"rester:home"},
+		})
+	}
+
+	repository := Repository{Name: "local"}
+	assert.Empty(t, checkForgetGuard(repository, backups, snapshots, snapshots[:3]))
+	assert.Contains(t, checkForgetGuard(repository, backups, snapshots, snapshots), "last snapshot of backup [home]")
+
+	repository.ForgetGuard.MaxRemoveCount = 2
+	assert.Empty(t, checkForgetGuard(repository, backups, snapshots, snapshots[:2]))
+	assert.Contains(t, checkForgetGuard(repository, backups, snapshots, snapshots[:3]), "max_remove_count")
+
+	repository.ForgetGuard = ForgetGuard{MaxRemovePercentage: 50}
+	assert.Empty(t, checkForgetGuard(repository, backups, snapshots, snapshots[:2]))
+	assert.Contains(t, checkForgetGuard(repository, backups, snapshots, snapshots[:3]), "max_remove_percentage")
+}
+
+func TestRemovesLastSnapshotOfStdinSource(t *testing.T) {
+	backups := []Backup{{
+		Name: "db", Host: "box",
+		StdinSources: []StdinSource{{Command: "dump one", Filename: "one.sql"}, {Command: "dump two", Filename: "two.sql"}},
+	}}
+
+	snapshots := []Snapshot{
+		{ID: "a", Hostname: "box", Paths: []string{"/one.sql"}, Tags: []string{"rester:db"}},
+		{ID: "b", Hostname: "box", Paths: []string{"/one.sql"}, Tags: []string{"rester:db"}},
+		{ID: "c", Hostname: "box", Paths: []string{"/two.sql"}, Tags: []string{"rester:db"}},
+	}
+
+	assert.Empty(t, removesLastSnapshot(backups, snapshots, snapshots[:1]))
+	assert.Equal(t, "db", removesLastSnapshot(backups, snapshots, snapshots[2:]))
+	assert.Empty(t, removesLastSnapshot(backups, snapshots[:2], nil))
+}
+
+func TestProtectRecentSnapshots(t *testing.T) {
+	now := time.Now()
+	remove := []Snapshot{
+		{ID: "a", Time: now.Add(-48 * time.Hour)},
+		{ID: "b", Time: now.Add(-2 * time.Hour)},
+	}
+
+	r := Restic{}
+	repository := Repository{Name: "local"}
+	assert.Len(t, r.protectRecentSnapshots(repository, remove, now), 2)
+
+	repository.ForgetGuard.MinAge = jsonutil.Duration{Duration: 24 * time.Hour}
+	kept := r.protectRecentSnapshots(repository, remove, now)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, "a", kept[0].ID)
+}
+
+func TestForgetGroupKeepReasons(t *testing.T) {
+	output := `[{
+		"tags": null, "host": "box", "paths": ["/home"],
+		"keep": [{"id": "a", "time": "2023-01-02T10:00:00Z", "tags": ["rester:home"]}],
+		"remove": [{"id": "b", "time": "2023-01-01T10:00:00Z", "tags": ["rester:home"]}],
+		"reasons": [{"snapshot": {"id": "a"}, "matches": ["daily snapshot", "weekly snapshot"], "counters": {"daily": 6}}]
+	}]`
+
+	var groups []ForgetGroup
+	assert.NoError(t, json.Unmarshal([]byte(output), &groups))
+	assert.Len(t, groups, 1)
+
+	group := groups[0]
+	assert.Equal(t, []string{"daily snapshot", "weekly snapshot"}, group.KeepReasons(group.Keep[0]))
+	assert.Empty(t, group.KeepReasons(group.Remove[0]))
+	assert.Equal(t, "home", group.Remove[0].BackupName())
+
+	now := time.Date(2023, 1, 2, 12, 0, 0, 0, time.UTC)
+	repository := Repository{Name: "local"}
+	repository.ForgetGuard.MinAge = jsonutil.Duration{Duration: 48 * time.Hour}
+
+	group = protectRecentGroupSnapshots(repository, group, now)
+	assert.Len(t, group.Keep, 2)
+	assert.Empty(t, group.Remove)
+	assert.Equal(t, []string{"min_age"}, group.KeepReasons(group.Keep[1]))
+}
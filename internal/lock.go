@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// RepositoryLock is a lock inside a restic repository as shown by "restic cat lock".
+type RepositoryLock struct {
+	ID        string    `json:"-"`
+	Time      time.Time `json:"time"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+}
+
+// IsStale reports whether the lock is older than staleAfter or was created by
+// a process on this host which does not exist anymore.
+func (l RepositoryLock) IsStale(staleAfter time.Duration) bool {
+	hostname, _ := os.Hostname()
+	return l.isStale(staleAfter, time.Now(), hostname, processExists)
+}
+
+func (l RepositoryLock) isStale(
+	staleAfter time.Duration, now time.Time, hostname string, processExists func(int) bool,
+) bool {
+	if now.Sub(l.Time) > staleAfter {
+		return true
+	}
+	return l.Hostname == hostname && !processExists(l.PID)
+}
+
+// GetLocks returns all locks currently present in the repository.
+func (r Restic) GetLocks(repository Repository) ([]RepositoryLock, error) {
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "list", "locks", "--no-lock")
+
+	if r.dryRun {
+		printDryRun(fmt.Sprintf("list locks of repository [%s]", repository.Name), cmd)
+		return nil, nil
+	}
+
+	r.logStep("list locks of repository [%s]: %s", repository.Name, formatCommandLine(cmd.Args))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, resticError(err, stderr.String())
+	}
+
+	var locks []RepositoryLock
+
+	for _, id := range strings.Fields(string(output)) {
+		cmd := r.prepareResticCommand(repository, make(map[string]string))
+		cmd.Args = append(cmd.Args, "cat", "lock", id, "--no-lock")
+
+		stderr.Reset()
+		cmd.Stderr = &stderr
+
+		lockOutput, err := cmd.Output()
+		if err != nil {
+			// the lock may have been removed in the meantime
+			r.logStep("failed to read lock %s of repository [%s]: %s", id, repository.Name, err)
+			continue
+		}
+
+		lock := RepositoryLock{ID: id}
+		if err := json.Unmarshal(lockOutput, &lock); err != nil {
+			return nil, err
+		}
+
+		locks = append(locks, lock)
+	}
+
+	return locks, nil
+}
+
+// Unlock removes stale locks from the repository or all locks if removeAll is set.
+func (r Restic) Unlock(repository Repository, removeAll bool) error {
+	return r.runUnlock(repository, removeAll)
+}
+
+// prepareLocks handles existing locks according to the lock strategy of the
+// repository before running a restic command.
+func (r Restic) prepareLocks(repository Repository) error {
+
+	if repository.Lock.Strategy != LockStrategyStaleOnly {
+		// "wait" is handled by restic itself using --retry-lock
+		return nil
+	}
+
+	locks, err := r.GetLocks(repository)
+	if err != nil {
+		return err
+	}
+
+	stale, protected := locksToUnlock(locks, repository.Lock.StaleAfter.Duration, RepositoryLock.IsStale)
+
+	if protected != nil {
+		r.logStep(
+			"keep stale locks of repository [%s] as restic would also remove lock %s younger than stale_after",
+			repository.Name, protected.ID,
+		)
+		return nil
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	for _, lock := range stale {
+		r.logStep("found stale lock %s of repository [%s]", lock.ID, repository.Name)
+	}
+
+	return r.runUnlock(repository, false)
+}
+
+// locksToUnlock returns the locks which are stale according to staleAfter.
+// "restic unlock" removes all locks restic considers stale i.e. locks older
+// than 30 minutes. If any of those is younger than staleAfter it is returned
+// as protected instead and no lock must be removed.
+func locksToUnlock(
+	locks []RepositoryLock, staleAfter time.Duration, isStale func(RepositoryLock, time.Duration) bool,
+) ([]RepositoryLock, *RepositoryLock) {
+
+	var stale []RepositoryLock
+
+	for i, lock := range locks {
+		if isStale(lock, staleAfter) {
+			stale = append(stale, lock)
+		} else if isStale(lock, minLockStaleAfter) {
+			return nil, &locks[i]
+		}
+	}
+
+	return stale, nil
+}
+
+func (r Restic) runUnlock(repository Repository, removeAll bool) error {
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "unlock")
+
+	if removeAll {
+		cmd.Args = append(cmd.Args, "--remove-all")
+	}
+
+	return r.runRestic(fmt.Sprintf("unlock repository [%s]", repository.Name), cmd)
+}
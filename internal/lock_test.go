@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryLockIsStale(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	alive := func(int) bool { return true }
+	dead := func(int) bool { return false }
+
+	lock := RepositoryLock{Time: now.Add(-10 * time.Minute), Hostname: "other", PID: 42}
+
+	assert.False(t, lock.isStale(30*time.Minute, now, "local", alive))
+	assert.False(t, lock.isStale(30*time.Minute, now, "local", dead), "process of other hosts can't be checked")
+	assert.True(t, lock.isStale(5*time.Minute, now, "local", alive))
+
+	lock.Hostname = "local"
+	assert.False(t, lock.isStale(30*time.Minute, now, "local", alive))
+	assert.True(t, lock.isStale(30*time.Minute, now, "local", dead))
+}
+
+func TestLocksToUnlock(t *testing.T) {
+	now := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	isStale := func(lock RepositoryLock, staleAfter time.Duration) bool {
+		return lock.isStale(staleAfter, now, "local", func(int) bool { return false })
+	}
+
+	fresh := RepositoryLock{ID: "fresh", Time: now.Add(-10 * time.Minute), Hostname: "other"}
+	dead := RepositoryLock{ID: "dead", Time: now.Add(-10 * time.Minute), Hostname: "local"}
+	old := RepositoryLock{ID: "old", Time: now.Add(-3 * time.Hour), Hostname: "other"}
+	between := RepositoryLock{ID: "between", Time: now.Add(-1 * time.Hour), Hostname: "other"}
+
+	stale, protected := locksToUnlock([]RepositoryLock{fresh}, 2*time.Hour, isStale)
+	assert.Empty(t, stale)
+	assert.Nil(t, protected)
+
+	stale, protected = locksToUnlock([]RepositoryLock{fresh, dead, old}, 2*time.Hour, isStale)
+	assert.Equal(t, []RepositoryLock{dead, old}, stale)
+	assert.Nil(t, protected)
+
+	// restic would remove the lock older than 30 minutes as well
+	stale, protected = locksToUnlock([]RepositoryLock{old, between}, 2*time.Hour, isStale)
+	assert.Empty(t, stale)
+	assert.Equal(t, "between", protected.ID)
+
+	stale, protected = locksToUnlock([]RepositoryLock{old, between}, 30*time.Minute, isStale)
+	assert.Equal(t, []RepositoryLock{old, between}, stale)
+	assert.Nil(t, protected)
+}
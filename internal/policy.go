@@ -0,0 +1,434 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSimulatedSnapshots limits the snapshots of a simulation as each of them
+// forgets with the policy once.
+const maxSimulatedSnapshots = 100000
+
+var (
+	withinPattern   = regexp.MustCompile(`^(\d+[ymdh])+$`)
+	withinPart      = regexp.MustCompile(`(\d+)([ymdh])`)
+	schedulePattern = regexp.MustCompile(`^\s*(.+?)\s+for\s+(.+?)\s*$`)
+	spanPattern     = regexp.MustCompile(`^(\d+)\s*(years?|y|months?|m|weeks?|w|days?|d|hours?|h)$`)
+)
+
+// calendarDuration is a duration given in calendar units like restic's
+// "2y6m" for keep_within. Months and years vary in length so it is applied
+// to a time with AddDate.
+type calendarDuration struct {
+	Years, Months, Days, Hours int
+}
+
+func (d calendarDuration) isZero() bool {
+	return d.Years == 0 && d.Months == 0 && d.Days == 0 && d.Hours == 0
+}
+
+func (d calendarDuration) addTo(t time.Time) time.Time {
+	return t.AddDate(d.Years, d.Months, d.Days).Add(time.Duration(d.Hours) * time.Hour)
+}
+
+func (d calendarDuration) subtractFrom(t time.Time) time.Time {
+	return t.AddDate(-d.Years, -d.Months, -d.Days).Add(-time.Duration(d.Hours) * time.Hour)
+}
+
+// parseWithin parses a duration like restic's --keep-within e.g. "7d12h".
+func parseWithin(within string) (calendarDuration, error) {
+	var d calendarDuration
+
+	if !withinPattern.MatchString(within) {
+		return d, fmt.Errorf("invalid duration %s", within)
+	}
+
+	for _, part := range withinPart.FindAllStringSubmatch(within, -1) {
+		n, err := strconv.Atoi(part[1])
+		if err != nil {
+			return d, fmt.Errorf("invalid duration %s", within)
+		}
+
+		switch part[2] {
+		case "y":
+			d.Years += n
+		case "m":
+			d.Months += n
+		case "d":
+			d.Days += n
+		case "h":
+			d.Hours += n
+		}
+	}
+
+	return d, nil
+}
+
+// String returns the policy as arguments of restic's forget.
+func (p Policy) String() string {
+	return strings.Join(p.args(), " ")
+}
+
+// isValidWithin reports whether within is a valid keep_within duration. An
+// empty string doesn't keep snapshots by time.
+func isValidWithin(within string) bool {
+	if within == "" {
+		return true
+	}
+	_, err := parseWithin(within)
+	return err == nil
+}
+
+// policyBucket keeps the latest snapshot of the given number of periods e.g.
+// days. bucket returns the period of the nth snapshot.
+type policyBucket struct {
+	count  uint
+	bucket func(t time.Time, n int) int
+	last   int
+	reason string
+}
+
+// applyPolicy splits the snapshots into those kept and removed by the policy
+// like restic's forget does for a single group. The returned group contains
+// the snapshots newest first.
+func applyPolicy(policy Policy, snapshots []Snapshot) (ForgetGroup, error) {
+
+	var group ForgetGroup
+	var within calendarDuration
+
+	if policy.KeepWithin != "" {
+		var err error
+		if within, err = parseWithin(policy.KeepWithin); err != nil {
+			return group, err
+		}
+	}
+
+	sorted := append([]Snapshot{}, snapshots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	if policy.isEmpty() {
+		for _, snapshot := range sorted {
+			group.Keep = append(group.Keep, snapshot)
+			group.Reasons = append(group.Reasons, ForgetReason{Snapshot: snapshot, Matches: []string{"policy is empty"}})
+		}
+		return group, nil
+	}
+
+	if len(sorted) == 0 {
+		return group, nil
+	}
+
+	buckets := []policyBucket{
+		{policy.KeepLast, func(t time.Time, n int) int { return n }, -1, "last snapshot"},
+		{policy.KeepHourly, func(t time.Time, n int) int {
+			return t.Year()*1000000 + int(t.Month())*10000 + t.Day()*100 + t.Hour()
+		}, -1, "hourly snapshot"},
+		{policy.KeepDaily, func(t time.Time, n int) int {
+			return t.Year()*10000 + int(t.Month())*100 + t.Day()
+		}, -1, "daily snapshot"},
+		{policy.KeepWeekly, func(t time.Time, n int) int {
+			year, week := t.ISOWeek()
+			return year*100 + week
+		}, -1, "weekly snapshot"},
+		{policy.KeepMonthly, func(t time.Time, n int) int {
+			return t.Year()*100 + int(t.Month())
+		}, -1, "monthly snapshot"},
+		{policy.KeepYearly, func(t time.Time, n int) int {
+			return t.Year()
+		}, -1, "yearly snapshot"},
+	}
+
+	// keep_within is relative to the latest snapshot, not to the current time
+	withinStart := within.subtractFrom(sorted[0].Time)
+
+	for n, snapshot := range sorted {
+		var matches []string
+
+		for _, tags := range policy.KeepTags {
+			if hasAllTags(snapshot, strings.Split(tags, ",")) {
+				matches = append(matches, fmt.Sprintf("has tags [%s]", tags))
+			}
+		}
+
+		if !within.isZero() && snapshot.Time.After(withinStart) {
+			matches = append(matches, fmt.Sprintf("within %s", policy.KeepWithin))
+		}
+
+		for i := range buckets {
+			if buckets[i].count == 0 {
+				continue
+			}
+
+			if value := buckets[i].bucket(snapshot.Time, n); value != buckets[i].last {
+				buckets[i].last = value
+				buckets[i].count--
+				matches = append(matches, buckets[i].reason)
+			}
+		}
+
+		if len(matches) > 0 {
+			group.Keep = append(group.Keep, snapshot)
+			group.Reasons = append(group.Reasons, ForgetReason{Snapshot: snapshot, Matches: matches})
+		} else {
+			group.Remove = append(group.Remove, snapshot)
+		}
+	}
+
+	return group, nil
+}
+
+func hasAllTags(snapshot Snapshot, tags []string) bool {
+	for _, tag := range tags {
+		if !Contains(snapshot.Tags, strings.TrimSpace(tag)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyPolicy groups the snapshots according to the group_by setting of the
+// policy and applies the policy to each group like restic's forget does.
+func ApplyPolicy(policy Policy, snapshots []Snapshot) ([]ForgetGroup, error) {
+
+	groupBy := policy.GroupBy
+	if groupBy == "" {
+		groupBy = "host,paths"
+	}
+
+	if !isValidGroupBy(groupBy) {
+		return nil, fmt.Errorf("invalid group_by %s", groupBy)
+	}
+
+	var fields []string
+	for _, field := range strings.Split(groupBy, ",") {
+		fields = append(fields, strings.TrimSpace(field))
+	}
+
+	var keys []string
+	templates := make(map[string]ForgetGroup)
+	members := make(map[string][]Snapshot)
+
+	for _, snapshot := range snapshots {
+		var template ForgetGroup
+		var key []string
+
+		if Contains(fields, "host") {
+			template.Host = snapshot.Hostname
+			key = append(key, snapshot.Hostname)
+		}
+
+		if Contains(fields, "paths") {
+			template.Paths = append([]string{}, snapshot.Paths...)
+			sort.Strings(template.Paths)
+			key = append(key, strings.Join(template.Paths, "\x00"))
+		}
+
+		if Contains(fields, "tags") {
+			template.Tags = append([]string{}, snapshot.Tags...)
+			sort.Strings(template.Tags)
+			key = append(key, strings.Join(template.Tags, "\x00"))
+		}
+
+		groupKey := strings.Join(key, "\x01")
+		if _, ok := templates[groupKey]; !ok {
+			keys = append(keys, groupKey)
+			templates[groupKey] = template
+		}
+		members[groupKey] = append(members[groupKey], snapshot)
+	}
+
+	sort.Strings(keys)
+
+	var groups []ForgetGroup
+
+	for _, key := range keys {
+		group, err := applyPolicy(policy, members[key])
+		if err != nil {
+			return nil, err
+		}
+
+		template := templates[key]
+		group.Host, group.Paths, group.Tags = template.Host, template.Paths, template.Tags
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// Schedule creates synthetic snapshots at a fixed interval over a span of
+// time e.g. "hourly for 2 years".
+type Schedule struct {
+	Spec     string
+	interval calendarDuration
+	span     calendarDuration
+}
+
+// ParseSchedule parses a schedule given as "<interval> for <span>". The
+// interval is hourly, daily, weekly, monthly, yearly or "every <span>" and a
+// span is a number followed by a unit e.g. "2 years", "6 months", "36h".
+func ParseSchedule(spec string) (Schedule, error) {
+
+	schedule := Schedule{Spec: spec}
+
+	parts := schedulePattern.FindStringSubmatch(strings.ToLower(spec))
+	if parts == nil {
+		return schedule, fmt.Errorf("invalid schedule %q, expected e.g. \"hourly for 2 years\"", spec)
+	}
+
+	switch interval := parts[1]; interval {
+	case "hourly":
+		schedule.interval = calendarDuration{Hours: 1}
+	case "daily":
+		schedule.interval = calendarDuration{Days: 1}
+	case "weekly":
+		schedule.interval = calendarDuration{Days: 7}
+	case "monthly":
+		schedule.interval = calendarDuration{Months: 1}
+	case "yearly":
+		schedule.interval = calendarDuration{Years: 1}
+	default:
+		span, err := parseSpan(strings.TrimSpace(strings.TrimPrefix(interval, "every")))
+		if !strings.HasPrefix(interval, "every") || err != nil || span.isZero() {
+			return schedule, fmt.Errorf("invalid interval %q in schedule", interval)
+		}
+		schedule.interval = span
+	}
+
+	span, err := parseSpan(parts[2])
+	if err != nil {
+		return schedule, err
+	}
+	schedule.span = span
+
+	return schedule, nil
+}
+
+func parseSpan(span string) (calendarDuration, error) {
+	var d calendarDuration
+
+	parts := spanPattern.FindStringSubmatch(span)
+	if parts == nil {
+		return d, fmt.Errorf("invalid span %q in schedule", span)
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return d, fmt.Errorf("invalid span %q in schedule", span)
+	}
+
+	switch parts[2][0] {
+	case 'y':
+		d.Years = n
+	case 'm':
+		d.Months = n
+	case 'w':
+		d.Days = 7 * n
+	case 'd':
+		d.Days = n
+	case 'h':
+		d.Hours = n
+	}
+
+	return d, nil
+}
+
+// Snapshots returns the snapshots created by the schedule ending at end.
+// Host, paths and tags are copied from the template.
+func (s Schedule) Snapshots(end time.Time, template Snapshot) ([]Snapshot, error) {
+
+	var snapshots []Snapshot
+
+	for t := s.span.subtractFrom(end); !t.After(end); t = s.interval.addTo(t) {
+		if len(snapshots) == maxSimulatedSnapshots {
+			return nil, fmt.Errorf("schedule %q creates more than %d snapshots", s.Spec, maxSimulatedSnapshots)
+		}
+
+		snapshot := template
+		snapshot.ID = fmt.Sprintf("%08x", len(snapshots)+1)
+		snapshot.Time = t
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
+
+// SimulationStep is the state of the repository after forgetting at a point
+// in time of a simulation.
+type SimulationStep struct {
+	Time    time.Time
+	Created int
+	Kept    int
+	Oldest  time.Time
+}
+
+// PolicySimulation shows how the snapshots kept by a policy evolve if forget
+// runs after each snapshot.
+type PolicySimulation struct {
+	Steps  []SimulationStep
+	Groups []ForgetGroup
+}
+
+// SimulatePolicy creates the snapshots in chronological order and forgets
+// with the policy after each of them like a forget following every backup
+// would. The state after forgetting is recorded at the given number of evenly
+// spread steps. Groups hold the result of the last forget.
+func SimulatePolicy(policy Policy, snapshots []Snapshot, steps int) (PolicySimulation, error) {
+
+	var simulation PolicySimulation
+
+	if len(snapshots) > maxSimulatedSnapshots {
+		return simulation, fmt.Errorf("more than %d snapshots to simulate", maxSimulatedSnapshots)
+	}
+
+	sorted := append([]Snapshot{}, snapshots...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time.Before(sorted[j].Time)
+	})
+
+	if steps < 1 {
+		steps = 1
+	}
+
+	var kept []Snapshot
+	nextStep := 1
+
+	for n, snapshot := range sorted {
+		groups, err := ApplyPolicy(policy, append(kept, snapshot))
+		if err != nil {
+			return simulation, err
+		}
+
+		kept = kept[:0:0]
+		for _, group := range groups {
+			kept = append(kept, group.Keep...)
+		}
+
+		// record the step once the share of snapshots created reaches it
+		if (n+1)*steps < nextStep*len(sorted) {
+			continue
+		}
+
+		for (n+1)*steps >= nextStep*len(sorted) {
+			nextStep++
+		}
+
+		step := SimulationStep{Time: snapshot.Time, Created: n + 1, Kept: len(kept)}
+		for _, k := range kept {
+			if step.Oldest.IsZero() || k.Time.Before(step.Oldest) {
+				step.Oldest = k.Time
+			}
+		}
+
+		simulation.Steps = append(simulation.Steps, step)
+		simulation.Groups = groups
+	}
+
+	return simulation, nil
+}
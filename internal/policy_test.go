@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hourlySnapshots returns snapshots taken every hour for the given number of
+// hours ending at end.
+func hourlySnapshots(end time.Time, hours int) []Snapshot {
+	var snapshots []Snapshot
+	for i := hours - 1; i >= 0; i-- {
+		snapshots = append(snapshots, Snapshot{
+			ID: fmt.Sprintf("%d", i), Time: end.Add(-time.Duration(i) * time.Hour),
+			Hostname: "box", Paths: []string{"/home"},
+		})
+	}
+	return snapshots
+}
+
+func ids(snapshots []Snapshot) []string {
+	var result []string
+	for _, snapshot := range snapshots {
+		result = append(result, snapshot.ID)
+	}
+	return result
+}
+
+func TestParseWithin(t *testing.T) {
+	d, err := parseWithin("2y5m7d3h")
+	assert.NoError(t, err)
+	assert.Equal(t, calendarDuration{Years: 2, Months: 5, Days: 7, Hours: 3}, d)
+
+	d, err = parseWithin("36h")
+	assert.NoError(t, err)
+	assert.Equal(t, calendarDuration{Hours: 36}, d)
+
+	for _, within := range []string{"", "7", "7 days", "7w", "d7"} {
+		_, err = parseWithin(within)
+		assert.Error(t, err, within)
+	}
+
+	assert.True(t, isValidWithin(""))
+	assert.False(t, isValidWithin("1 year"))
+}
+
+func TestApplyPolicyKeepLastAndDaily(t *testing.T) {
+	end := time.Date(2023, 3, 10, 23, 0, 0, 0, time.UTC)
+	snapshots := hourlySnapshots(end, 72)
+
+	group, err := applyPolicy(Policy{KeepLast: 2, KeepDaily: 3}, snapshots)
+	assert.NoError(t, err)
+
+	// the latest two snapshots and the latest snapshot of each of three days
+	assert.Equal(t, []string{"0", "1", "24", "48"}, ids(group.Keep))
+	assert.Len(t, group.Remove, 68)
+	assert.Equal(t, []string{"last snapshot", "daily snapshot"}, group.KeepReasons(group.Keep[0]))
+	assert.Equal(t, []string{"daily snapshot"}, group.KeepReasons(group.Keep[2]))
+}
+
+func TestApplyPolicyWeeklyMonthlyYearly(t *testing.T) {
+	var snapshots []Snapshot
+	start := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	for d := 0; d < 3*365; d++ {
+		snapshots = append(snapshots, Snapshot{ID: fmt.Sprintf("%d", d), Time: start.AddDate(0, 0, d)})
+	}
+
+	group, err := applyPolicy(Policy{KeepWeekly: 2, KeepMonthly: 3, KeepYearly: 5}, snapshots)
+	assert.NoError(t, err)
+
+	var times []string
+	for _, snapshot := range group.Keep {
+		times = append(times, snapshot.Time.Format("2006-01-02"))
+	}
+
+	// the last day of 2023 is a Sunday ending ISO week 52
+	assert.Equal(t, []string{
+		"2023-12-31", "2023-12-24", "2023-11-30", "2023-10-31", "2022-12-31", "2021-12-31",
+	}, times)
+	assert.Equal(t, []string{"weekly snapshot", "monthly snapshot", "yearly snapshot"}, group.KeepReasons(group.Keep[0]))
+}
+
+func TestApplyPolicyWithinAndTags(t *testing.T) {
+	end := time.Date(2023, 3, 10, 23, 0, 0, 0, time.UTC)
+	snapshots := hourlySnapshots(end, 48)
+	snapshots[0].Tags = []string{"keep", "db"}
+	snapshots[1].Tags = []string{"keep"}
+
+	// keep_within is relative to the latest snapshot
+	group, err := applyPolicy(Policy{KeepWithin: "3h", KeepTags: []string{"keep,db"}}, snapshots)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"0", "1", "2", "47"}, ids(group.Keep))
+	assert.Equal(t, []string{"within 3h"}, group.KeepReasons(group.Keep[0]))
+	assert.Equal(t, []string{"has tags [keep,db]"}, group.KeepReasons(group.Keep[3]))
+
+	_, err = applyPolicy(Policy{KeepWithin: "3 hours"}, snapshots)
+	assert.Error(t, err)
+}
+
+func TestApplyPolicyEmpty(t *testing.T) {
+	snapshots := hourlySnapshots(time.Now(), 5)
+
+	group, err := applyPolicy(Policy{}, snapshots)
+	assert.NoError(t, err)
+	assert.Len(t, group.Keep, 5)
+	assert.Empty(t, group.Remove)
+	assert.Equal(t, []string{"policy is empty"}, group.KeepReasons(group.Keep[0]))
+}
+
+func TestApplyPolicyGroups(t *testing.T) {
+	now := time.Now()
+	snapshots := []Snapshot{
+		{ID: "a1", Time: now.Add(-2 * time.Hour), Hostname: "a", Paths: []string{"/home", "/etc"}, Tags: []string{"x"}},
+		{ID: "a2", Time: now.Add(-1 * time.Hour), Hostname: "a", Paths: []string{"/etc", "/home"}, Tags: []string{"y"}},
+		{ID: "b1", Time: now, Hostname: "b", Paths: []string{"/home"}, Tags: []string{"x"}},
+	}
+
+	groups, err := ApplyPolicy(Policy{KeepLast: 1}, snapshots)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Equal(t, "a", groups[0].Host)
+	assert.Equal(t, []string{"/etc", "/home"}, groups[0].Paths)
+	assert.Equal(t, []string{"a2"}, ids(groups[0].Keep))
+	assert.Equal(t, []string{"a1"}, ids(groups[0].Remove))
+	assert.Equal(t, []string{"b1"}, ids(groups[1].Keep))
+
+	groups, err = ApplyPolicy(Policy{KeepLast: 1, GroupBy: "tags"}, snapshots)
+	assert.NoError(t, err)
+	assert.Len(t, groups, 2)
+	assert.Empty(t, groups[0].Host)
+	assert.Equal(t, []string{"x"}, groups[0].Tags)
+	assert.Equal(t, []string{"b1"}, ids(groups[0].Keep))
+	assert.Equal(t, []string{"a2"}, ids(groups[1].Keep))
+
+	_, err = ApplyPolicy(Policy{KeepLast: 1, GroupBy: "user"}, snapshots)
+	assert.Error(t, err)
+}
+
+func TestParseSchedule(t *testing.T) {
+	end := time.Date(2023, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	for spec, count := range map[string]int{
+		"hourly for 2 days":    49,
+		"Daily for 1 week":     8,
+		"weekly for 4w":        5,
+		"monthly for 2 years":  25,
+		"yearly for 3y":        4,
+		"every 6h for 36h":     7,
+		"every 2 days for 10d": 6,
+	} {
+		schedule, err := ParseSchedule(spec)
+		assert.NoError(t, err, spec)
+
+		snapshots, err := schedule.Snapshots(end, Snapshot{Hostname: "box"})
+		assert.NoError(t, err, spec)
+		assert.Len(t, snapshots, count, spec)
+		assert.Equal(t, end, snapshots[len(snapshots)-1].Time, spec)
+		assert.Equal(t, "box", snapshots[0].Hostname, spec)
+	}
+
+	for _, spec := range []string{"", "hourly", "hourly for ever", "often for 2 days", "every 0h for 2 days", "every for 2d"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+
+	schedule, err := ParseSchedule("hourly for 20 years")
+	assert.NoError(t, err)
+	_, err = schedule.Snapshots(end, Snapshot{})
+	assert.Error(t, err)
+}
+
+func TestSimulatePolicy(t *testing.T) {
+	end := time.Date(2023, 3, 10, 23, 0, 0, 0, time.UTC)
+	snapshots := hourlySnapshots(end, 96)
+
+	simulation, err := SimulatePolicy(Policy{KeepLast: 3, KeepDaily: 2}, snapshots, 4)
+	assert.NoError(t, err)
+	assert.Len(t, simulation.Steps, 4)
+
+	// the first day keeps its latest snapshot as part of the last three
+	for i, step := range simulation.Steps {
+		assert.Equal(t, (i+1)*24, step.Created)
+		if i == 0 {
+			assert.Equal(t, 3, step.Kept)
+		} else {
+			assert.Equal(t, 4, step.Kept)
+		}
+	}
+
+	last := simulation.Steps[3]
+	assert.Equal(t, end, last.Time)
+	assert.Equal(t, end.Add(-24*time.Hour), last.Oldest)
+
+	assert.Len(t, simulation.Groups, 1)
+	assert.Equal(t, []string{"0", "1", "2", "24"}, ids(simulation.Groups[0].Keep))
+
+	// more steps than snapshots shows each snapshot
+	simulation, err = SimulatePolicy(Policy{KeepLast: 3}, snapshots[:2], 10)
+	assert.NoError(t, err)
+	assert.Len(t, simulation.Steps, 2)
+
+	_, err = SimulatePolicy(Policy{KeepWithin: "forever"}, snapshots, 4)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "forever"))
+}
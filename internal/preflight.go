@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	shlex "github.com/anmitsu/go-shlex"
+)
+
+// ErrPreflight is returned if the pre-flight checks of a backup found problems.
+var ErrPreflight = errors.New("pre-flight check failed")
+
+var errDiskSpaceUnsupported = errors.New("free disk space not supported on this platform")
+
+// Preflight checks whether the backup is likely to succeed before running
+// restic: all data paths exist and are readable, the excludes are plausible,
+// the restic cache has the configured free space and all stdin and snapshot provider
+// commands are found.
+// It returns a description of each problem found.
+func (r Restic) Preflight(backup Backup, repository Repository) []string {
+
+	var problems []string
+
+	r.logStep("pre-flight check of backup [%s] for repository [%s]", backup.Name, repository.Name)
+
+	for _, path := range backup.Data {
+		if err := checkReadable(path); err != nil {
+			problems = append(problems, fmt.Sprintf("data %s is not readable: %s", path, err))
+		}
+	}
+
+	for _, exclude := range backup.Exclude {
+		if problem := checkExclude(exclude, backup.Data); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	for _, iexclude := range backup.IExclude {
+		// case insensitive patterns are only checked for a valid syntax
+		if problem := checkExclude(iexclude, nil); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	for _, path := range backup.ExcludeFile {
+		if err := checkReadable(path); err != nil {
+			problems = append(problems, fmt.Sprintf("exclude file %s is not readable: %s", path, err))
+		}
+	}
+
+	for _, path := range append(append([]string{}, backup.FilesFrom...), backup.FilesFromVerbatim...) {
+		if err := checkReadable(path); err != nil {
+			problems = append(problems, fmt.Sprintf("files from %s is not readable: %s", path, err))
+		}
+	}
+
+	for _, source := range backup.GetStdinSources() {
+		if err := checkCommand(source.Command); err != nil {
+			problems = append(problems, fmt.Sprintf("stdin command %s: %s", source.Command, err))
+		}
+		if source.Validation.Command != "" {
+			if err := checkCommand(source.Validation.Command); err != nil {
+				problems = append(problems, fmt.Sprintf("stdin validation command %s: %s", source.Validation.Command, err))
+			}
+		}
+	}
+
+	// the priority is set by running the commands using nice and ionice
+	wrapped := applyResources(exec.Command(""), backup.overrideRepository(repository).Resources)
+	for _, arg := range wrapped.Args {
+		if arg != "nice" && arg != "ionice" {
+			continue
+		}
+		if _, err := exec.LookPath(arg); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", arg, err))
+		}
+	}
+
+	for _, database := range backup.Databases {
+		if database.Type != DatabaseSQLite {
+			continue
+		}
+		if err := checkReadable(database.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("database %s is not readable: %s", database.Path, err))
+		}
+	}
+
+	provider := backup.SnapshotProvider
+	if provider.isEnabled() {
+		commands := []string{provider.Create, provider.Destroy}
+		for _, args := range append(provider.createCommands(backup.Name), provider.destroyCommands(backup.Name)...) {
+			commands = append(commands, args[0])
+		}
+
+		checked := make(map[string]bool)
+		for _, command := range commands {
+			if command == "" || checked[command] {
+				continue
+			}
+			checked[command] = true
+			if err := checkCommand(command); err != nil {
+				problems = append(problems, fmt.Sprintf("snapshot provider command %s: %s", command, err))
+			}
+		}
+	}
+
+	if problem := checkCacheFreeSpace(backup, repository); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	return problems
+}
+
+func preflightError(problems []string) error {
+	return fmt.Errorf("%w: %s", ErrPreflight, strings.Join(problems, "; "))
+}
+
+// defaultCacheMinFreeSpace is the free space restic's cache directory needs
+// unless the repository sets cache_min_free_space.
+const defaultCacheMinFreeSpace = 1 << 30
+
+// checkCacheFreeSpace returns a problem if restic's cache directory has less
+// free space than the cache_min_free_space of the repository or 1 GiB by
+// default. Restic needs some space for its cache even if most of the cache is
+// present.
+func checkCacheFreeSpace(backup Backup, repository Repository) string {
+
+	minFree, err := cacheMinFreeSpace(repository)
+	if err != nil {
+		return fmt.Sprintf("cache_min_free_space: %s", err)
+	}
+
+	cacheDir := resticCacheDir(backup, repository)
+	if cacheDir == "" {
+		return ""
+	}
+
+	free, err := freeDiskSpace(existingParent(cacheDir))
+	if err == errDiskSpaceUnsupported {
+		return ""
+	} else if err != nil {
+		return fmt.Sprintf("failed to get free space of cache dir %s: %s", cacheDir, err)
+	}
+
+	if free < minFree {
+		return fmt.Sprintf(
+			"cache dir %s has only %s free space, at least %s needed",
+			cacheDir, formatBytes(free), formatBytes(minFree),
+		)
+	}
+
+	return ""
+}
+
+// cacheMinFreeSpace returns the free space restic's cache directory needs.
+func cacheMinFreeSpace(repository Repository) (uint64, error) {
+	if repository.CacheMinFreeSpace == "" {
+		return defaultCacheMinFreeSpace, nil
+	}
+	return parseSize(repository.CacheMinFreeSpace)
+}
+
+func checkReadable(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if _, err := file.Readdirnames(1); err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkExclude returns a problem if the exclude pattern is invalid or if it is
+// an absolute pattern outside of all data paths which can never match.
+func checkExclude(exclude string, data []string) string {
+
+	if strings.TrimSpace(exclude) == "" {
+		return "exclude is empty"
+	}
+
+	if _, err := filepath.Match(exclude, ""); err != nil {
+		return fmt.Sprintf("exclude %s is not a valid pattern: %s", exclude, err)
+	}
+
+	if len(data) == 0 || !filepath.IsAbs(exclude) {
+		return ""
+	}
+
+	metaCharacters := "*?["
+	if runtime.GOOS != "windows" {
+		metaCharacters += "\\"
+	}
+
+	prefix := exclude
+	if i := strings.IndexAny(exclude, metaCharacters); i >= 0 {
+		prefix = exclude[:i]
+	}
+
+	separator := string(filepath.Separator)
+
+	for _, path := range data {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+
+		if strings.HasPrefix(path, prefix) || prefix == path || strings.HasPrefix(prefix, strings.TrimSuffix(path, separator)+separator) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("exclude %s is outside of all data paths", exclude)
+}
+
+func checkCommand(command string) error {
+	args, err := shlex.Split(command, true)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New("command is empty")
+	}
+
+	_, err = exec.LookPath(args[0])
+	return err
+}
+
+// resticCacheDir returns the cache directory restic is going to use or an
+// empty string if restic runs without cache.
+func resticCacheDir(backup Backup, repository Repository) string {
+
+	flags := append(append([]string{}, repository.CustomFlags...), backup.CustomFlags...)
+
+	for i, flag := range flags {
+		switch {
+		case flag == "--no-cache":
+			return ""
+		case flag == "--cache-dir" && i+1 < len(flags):
+			return flags[i+1]
+		case strings.HasPrefix(flag, "--cache-dir="):
+			return strings.TrimPrefix(flag, "--cache-dir=")
+		}
+	}
+
+	environment := combineMaps(repository.Environment, backup.Environment)
+
+	if cacheDir := environment["RESTIC_CACHE_DIR"]; cacheDir != "" {
+		return cacheDir
+	}
+
+	if cacheDir := os.Getenv("RESTIC_CACHE_DIR"); cacheDir != "" {
+		return cacheDir
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(cacheDir, "restic")
+}
+
+// existingParent returns path or its nearest parent directory which exists.
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
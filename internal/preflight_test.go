@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckExclude(t *testing.T) {
+	data := []string{"/home/user", "/etc"}
+
+	assert.Equal(t, "", checkExclude("*.tmp", data))
+	assert.Equal(t, "", checkExclude("/home/user/.cache", data))
+	assert.Equal(t, "", checkExclude("/home/*/.cache", data))
+	assert.Equal(t, "", checkExclude("/etc/ssl/*", data))
+	assert.Equal(t, "", checkExclude("/var/cache", nil))
+
+	assert.Equal(t, "exclude is empty", checkExclude(" ", data))
+	assert.Equal(t, "exclude /var/cache is outside of all data paths", checkExclude("/var/cache", data))
+	assert.Equal(t, "exclude /etcetera is outside of all data paths", checkExclude("/etcetera", data))
+	assert.Contains(t, checkExclude("[a-", data), "is not a valid pattern")
+}
+
+func TestCheckReadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("data"), 0600))
+
+	assert.Nil(t, checkReadable(dir))
+	assert.Nil(t, checkReadable(file))
+	assert.NotNil(t, checkReadable(filepath.Join(dir, "missing")))
+}
+
+func TestCheckCommand(t *testing.T) {
+	assert.Nil(t, checkCommand("sh -c 'echo test'"))
+	assert.NotNil(t, checkCommand("rester-command-which-does-not-exist --all"))
+	assert.NotNil(t, checkCommand(""))
+}
+
+func TestResticCacheDir(t *testing.T) {
+	backup := Backup{}
+	repository := Repository{Environment: map[string]string{"RESTIC_CACHE_DIR": "/var/cache/restic"}}
+
+	assert.Equal(t, "/var/cache/restic", resticCacheDir(backup, repository))
+
+	backup.CustomFlags = []string{"--cache-dir", "/tmp/cache"}
+	assert.Equal(t, "/tmp/cache", resticCacheDir(backup, repository))
+
+	backup.CustomFlags = []string{"--cache-dir=/tmp/cache2"}
+	assert.Equal(t, "/tmp/cache2", resticCacheDir(backup, repository))
+
+	repository.CustomFlags = []string{"--no-cache"}
+	assert.Equal(t, "", resticCacheDir(backup, repository))
+}
+
+func TestCheckCacheFreeSpace(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("free disk space is only tested on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	backup := Backup{CustomFlags: []string{"--cache-dir", dir}}
+
+	minFree, err := cacheMinFreeSpace(Repository{})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<30), minFree)
+
+	repository := Repository{}
+	repository.CacheMinFreeSpace = "1K"
+	assert.Equal(t, "", checkCacheFreeSpace(backup, repository))
+
+	repository.CacheMinFreeSpace = "100000T"
+	assert.Contains(t, checkCacheFreeSpace(backup, repository), "at least")
+}
+
+func TestExistingParent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Equal(t, dir, existingParent(dir))
+	assert.Equal(t, dir, existingParent(filepath.Join(dir, "a", "b")))
+}
@@ -0,0 +1,9 @@
+package internal
+
+import "os"
+
+func processExists(pid int) bool {
+	// FindProcess fails on windows if the process does not exist
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
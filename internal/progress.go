@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const progressRedrawInterval = 200 * time.Millisecond
+const progressLogInterval = time.Minute
+const progressBarWidth = 25
+
+type BackupSummary struct {
+	FilesNew            uint64  `json:"files_new"`
+	FilesChanged        uint64  `json:"files_changed"`
+	FilesUnmodified     uint64  `json:"files_unmodified"`
+	DataAdded           uint64  `json:"data_added"`
+	TotalFilesProcessed uint64  `json:"total_files_processed"`
+	TotalBytesProcessed uint64  `json:"total_bytes_processed"`
+	TotalDuration       float64 `json:"total_duration"`
+	SnapshotID          string  `json:"snapshot_id"`
+}
+
+type backupStatus struct {
+	SecondsElapsed   uint64  `json:"seconds_elapsed"`
+	SecondsRemaining uint64  `json:"seconds_remaining"`
+	PercentDone      float64 `json:"percent_done"`
+	TotalFiles       uint64  `json:"total_files"`
+	FilesDone        uint64  `json:"files_done"`
+	TotalBytes       uint64  `json:"total_bytes"`
+	BytesDone        uint64  `json:"bytes_done"`
+}
+
+type backupVerboseStatus struct {
+	Action string `json:"action"`
+	Item   string `json:"item"`
+}
+
+type backupError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	During string `json:"during"`
+	Item   string `json:"item"`
+}
+
+// backupProgress parses the JSON messages of "restic backup --json" written
+// to it and reports the progress either as a progress bar on a terminal or as
+// periodic log lines.
+type backupProgress struct {
+	name       string
+	output     io.Writer
+	errOutput  io.Writer
+	terminal   bool
+	now        func() time.Time
+	lastUpdate time.Time
+	lineLength int
+	buffer     []byte
+	summary    *BackupSummary
+	errors     bytes.Buffer
+}
+
+func newBackupProgress(name string) *backupProgress {
+	return &backupProgress{
+		name:       name,
+		output:     os.Stdout,
+		errOutput:  os.Stderr,
+		terminal:   isTerminal(os.Stdout),
+		now:        time.Now,
+		lastUpdate: time.Now(),
+	}
+}
+
+func (p *backupProgress) Write(data []byte) (int, error) {
+	p.buffer = append(p.buffer, data...)
+
+	for {
+		i := bytes.IndexByte(p.buffer, '\n')
+		if i < 0 {
+			break
+		}
+		p.handleLine(p.buffer[:i])
+		p.buffer = p.buffer[i+1:]
+	}
+
+	return len(data), nil
+}
+
+// Close handles any incomplete last line and terminates the progress bar.
+func (p *backupProgress) Close() {
+	if len(p.buffer) > 0 {
+		p.handleLine(p.buffer)
+		p.buffer = nil
+	}
+	p.clearLine()
+}
+
+// ErrorOutput returns all output of restic which is not a JSON message.
+func (p *backupProgress) ErrorOutput() string {
+	return p.errors.String()
+}
+
+// Summary returns the summary reported by restic or nil if there was none.
+func (p *backupProgress) Summary() *BackupSummary {
+	return p.summary
+}
+
+func (p *backupProgress) handleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var message struct {
+		MessageType string `json:"message_type"`
+	}
+
+	if err := json.Unmarshal(line, &message); err != nil || message.MessageType == "" {
+		// not a JSON message e.g. a fatal error
+		p.clearLine()
+		fmt.Fprintln(p.errOutput, string(line))
+		p.errors.Write(line)
+		p.errors.WriteByte('\n')
+		return
+	}
+
+	switch message.MessageType {
+	case "status":
+		var status backupStatus
+		if err := json.Unmarshal(line, &status); err == nil {
+			p.handleStatus(status)
+		}
+	case "verbose_status":
+		var status backupVerboseStatus
+		if err := json.Unmarshal(line, &status); err == nil && status.Item != "" {
+			p.clearLine()
+			fmt.Fprintf(p.output, "%-10s %s\n", status.Action, status.Item)
+		}
+	case "error":
+		var e backupError
+		if err := json.Unmarshal(line, &e); err == nil {
+			p.clearLine()
+			fmt.Fprintf(p.errOutput, "error during %s of %s: %s\n", e.During, e.Item, e.Error.Message)
+		}
+	case "summary":
+		var summary BackupSummary
+		if err := json.Unmarshal(line, &summary); err == nil {
+			p.summary = &summary
+			p.clearLine()
+			fmt.Fprintln(p.output, formatBackupSummary(p.name, summary))
+		}
+	}
+}
+
+func (p *backupProgress) handleStatus(status backupStatus) {
+	now := p.now()
+
+	if p.terminal {
+		if now.Sub(p.lastUpdate) < progressRedrawInterval {
+			return
+		}
+		line := formatProgressBar(status)
+		fmt.Fprintf(p.output, "\r%s\x1b[K", line)
+		p.lineLength = len(line)
+	} else {
+		if now.Sub(p.lastUpdate) < progressLogInterval {
+			return
+		}
+		fmt.Fprintf(p.output, "%s: %s\n", p.name, formatProgress(status))
+	}
+
+	p.lastUpdate = now
+}
+
+func (p *backupProgress) clearLine() {
+	if p.terminal && p.lineLength > 0 {
+		fmt.Fprint(p.output, "\r\x1b[K")
+		p.lineLength = 0
+	}
+}
+
+func formatProgressBar(status backupStatus) string {
+	done := int(status.PercentDone * progressBarWidth)
+	if done > progressBarWidth {
+		done = progressBarWidth
+	}
+	if done < 0 {
+		done = 0
+	}
+
+	bar := strings.Repeat("=", done) + strings.Repeat(" ", progressBarWidth-done)
+
+	return fmt.Sprintf("[%s] %s", bar, formatProgress(status))
+}
+
+func formatProgress(status backupStatus) string {
+	eta := "-"
+	if status.SecondsRemaining > 0 {
+		eta = (time.Duration(status.SecondsRemaining) * time.Second).String()
+	}
+
+	return fmt.Sprintf(
+		"%.2f%% done, %s / %s, %d / %d files, ETA %s",
+		status.PercentDone*100,
+		formatBytes(status.BytesDone), formatBytes(status.TotalBytes),
+		status.FilesDone, status.TotalFiles,
+		eta,
+	)
+}
+
+func formatBackupSummary(name string, summary BackupSummary) string {
+	duration := time.Duration(summary.TotalDuration * float64(time.Second)).Round(time.Second)
+
+	return fmt.Sprintf(
+		"%s: snapshot %s saved, %d new, %d changed, %d unmodified files, +%s in %s",
+		name, shortID(summary.SnapshotID),
+		summary.FilesNew, summary.FilesChanged, summary.FilesUnmodified,
+		formatBytes(summary.DataAdded), duration,
+	)
+}
+
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
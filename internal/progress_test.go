@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(1536*1024))
+	assert.Equal(t, "2.0 GiB", formatBytes(2*1024*1024*1024))
+}
+
+func TestBackupProgressLog(t *testing.T) {
+	var output, errOutput bytes.Buffer
+
+	now := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	p := newBackupProgress("home/repo")
+	p.output = &output
+	p.errOutput = &errOutput
+	p.terminal = false
+	p.now = func() time.Time { return now }
+	p.lastUpdate = now
+
+	p.Write([]byte(`{"message_type":"status","percent_done":0.1,"total_files":10,"files_done":1,"total_bytes":2048,"bytes_done":1024}` + "\n"))
+	assert.Equal(t, "", output.String())
+
+	now = now.Add(progressLogInterval)
+	p.Write([]byte(`{"message_type":"status","percent_done":0.5,"seconds_remaining":90,`))
+	p.Write([]byte(`"total_files":10,"files_done":5,"total_bytes":2048,"bytes_done":1024}` + "\n"))
+	assert.Equal(t, "home/repo: 50.00% done, 1.0 KiB / 2.0 KiB, 5 / 10 files, ETA 1m30s\n", output.String())
+
+	output.Reset()
+	p.Write([]byte("Fatal: something went wrong\n"))
+	p.Write([]byte(`{"message_type":"summary","files_new":3,"files_changed":1,"files_unmodified":6,"data_added":2048,"total_duration":62.4,"snapshot_id":"abcdef12"}`))
+	p.Close()
+
+	assert.Equal(t, "Fatal: something went wrong\n", errOutput.String())
+	assert.Equal(t, "home/repo: snapshot abcdef12 saved, 3 new, 1 changed, 6 unmodified files, +2.0 KiB in 1m2s\n", output.String())
+
+	summary := p.Summary()
+	assert.NotNil(t, summary)
+	assert.Equal(t, "abcdef12", summary.SnapshotID)
+	assert.Equal(t, uint64(3), summary.FilesNew)
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotName is the name of filesystem snapshots created by the builtin
+// snapshot providers.
+func (p SnapshotProvider) snapshotName(backupName string) string {
+	return "rester-" + backupName
+}
+
+// mapPath returns the path inside the snapshot for a path below p.Path.
+func (p SnapshotProvider) mapPath(livePath string) (string, bool) {
+	return rebasePath(livePath, p.Path, p.MountPath)
+}
+
+// unmapPath returns the live path for a path inside the snapshot.
+func (p SnapshotProvider) unmapPath(snapshotPath string) (string, bool) {
+	return rebasePath(snapshotPath, p.MountPath, p.Path)
+}
+
+// unmapPaths returns the live paths of the paths of a restic snapshot taken
+// from the filesystem snapshot.
+func (p SnapshotProvider) unmapPaths(paths []string) []string {
+	if !p.isEnabled() {
+		return paths
+	}
+
+	result := make([]string, len(paths))
+	for i, path := range paths {
+		result[i], _ = p.unmapPath(path)
+	}
+	return result
+}
+
+func rebasePath(path, from, to string) (string, bool) {
+	rel, err := filepath.Rel(filepath.Clean(from), filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return path, false
+	}
+	return filepath.Join(to, rel), true
+}
+
+// providerStep is a command creating part of a filesystem snapshot and the
+// command undoing it, if any.
+type providerStep struct {
+	create []string
+	undo   []string
+}
+
+func (p SnapshotProvider) steps(backupName string) []providerStep {
+	name := p.snapshotName(backupName)
+
+	switch p.Type {
+	case SnapshotProviderBtrfs:
+		return []providerStep{
+			{
+				create: []string{"btrfs", "subvolume", "snapshot", "-r", p.Path, p.MountPath},
+				undo:   []string{"btrfs", "subvolume", "delete", p.MountPath},
+			},
+		}
+	case SnapshotProviderZFS:
+		return []providerStep{
+			{
+				create: []string{"zfs", "snapshot", p.Volume + "@" + name},
+				undo:   []string{"zfs", "destroy", p.Volume + "@" + name},
+			},
+		}
+	case SnapshotProviderLVM:
+		return []providerStep{
+			{
+				create: []string{"lvcreate", "--snapshot", "--size", p.Size, "--name", name, p.Volume},
+				undo:   []string{"lvremove", "--yes", path.Join(path.Dir(p.Volume), name)},
+			},
+			{
+				create: []string{"mkdir", "-p", p.MountPath},
+			},
+			{
+				create: []string{"mount", "-o", "ro", "/dev/" + path.Join(path.Dir(p.Volume), name), p.MountPath},
+				undo:   []string{"umount", p.MountPath},
+			},
+		}
+	}
+
+	return nil
+}
+
+func (p SnapshotProvider) createCommands(backupName string) [][]string {
+	var commands [][]string
+	for _, step := range p.steps(backupName) {
+		commands = append(commands, step.create)
+	}
+	return commands
+}
+
+// destroyCommands returns the commands undoing all steps in reverse order.
+func (p SnapshotProvider) destroyCommands(backupName string) [][]string {
+	var commands [][]string
+	for _, step := range p.steps(backupName) {
+		if step.undo != nil {
+			commands = append([][]string{step.undo}, commands...)
+		}
+	}
+	return commands
+}
+
+// runBackupFromSnapshot creates a filesystem snapshot, backs up the snapshot
+// instead of the live data and destroys the snapshot again in any case.
+func (r Restic) runBackupFromSnapshot(
+	backup Backup, repository Repository, environment map[string]string,
+) (*BackupSummary, error) {
+
+	provider := backup.SnapshotProvider
+	providerEnvironment := combineMaps(environment, map[string]string{
+		"RESTER_BACKUP_NAME":         backup.Name,
+		"RESTER_PROVIDER_PATH":       provider.Path,
+		"RESTER_PROVIDER_MOUNT_PATH": provider.MountPath,
+	})
+
+	undo, err := r.createFilesystemSnapshot(backup, providerEnvironment)
+
+	// destroy also cleans up after a partially created snapshot
+	defer r.destroyFilesystemSnapshot(backup, undo)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filesystem snapshot: %w", err)
+	}
+
+	snapshotBackup := backup
+	snapshotBackup.Data = make([]string, len(backup.Data))
+	for i, path := range backup.Data {
+		snapshotBackup.Data[i], _ = provider.mapPath(path)
+	}
+
+	snapshotBackup.Exclude = make([]string, len(backup.Exclude))
+	for i, exclude := range backup.Exclude {
+		// relative patterns match anywhere and stay untouched
+		snapshotBackup.Exclude[i], _ = provider.mapPath(exclude)
+	}
+
+	return r.runBackupCommands(snapshotBackup, repository, environment)
+}
+
+// createFilesystemSnapshot runs the custom create command of the provider or
+// the commands of a builtin provider up to the first failing one. It returns
+// the commands undoing what has been created in the order to run them. The
+// custom destroy command is returned once the create command has started as
+// it might have created part of the snapshot before failing.
+func (r Restic) createFilesystemSnapshot(backup Backup, environment map[string]string) ([]*exec.Cmd, error) {
+
+	provider := backup.SnapshotProvider
+
+	if provider.Type == SnapshotProviderCustom {
+		cmd, err := prepareShellCommand(provider.Create, environment, Resources{})
+		if err != nil {
+			return nil, err
+		}
+
+		var undo []*exec.Cmd
+		if provider.Destroy != "" {
+			destroy, err := prepareShellCommand(provider.Destroy, environment, Resources{})
+			if err != nil {
+				return nil, err
+			}
+			undo = append(undo, destroy)
+		}
+
+		return undo, r.runSnapshotProviderCommand(backup, "create", cmd)
+	}
+
+	var undo []*exec.Cmd
+
+	for _, step := range provider.steps(backup.Name) {
+		if err := r.runSnapshotProviderCommand(backup, "create", providerCommand(step.create, environment)); err != nil {
+			return undo, err
+		}
+
+		if step.undo != nil {
+			undo = append([]*exec.Cmd{providerCommand(step.undo, environment)}, undo...)
+		}
+	}
+
+	return undo, nil
+}
+
+// destroyFilesystemSnapshot runs all commands undoing the creation of the
+// snapshot to clean up as much as possible.
+func (r Restic) destroyFilesystemSnapshot(backup Backup, undo []*exec.Cmd) {
+	for _, cmd := range undo {
+		_ = r.runSnapshotProviderCommand(backup, "destroy", cmd)
+	}
+}
+
+func providerCommand(args []string, environment map[string]string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), convertEnvironment(environment)...)
+	return cmd
+}
+
+func (r Restic) runSnapshotProviderCommand(backup Backup, step string, cmd *exec.Cmd) error {
+
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err := r.run(fmt.Sprintf("%s filesystem snapshot for backup [%s]", step, backup.Name), cmd)
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to %s filesystem snapshot for backup [%s] using \"%s\": %s\n",
+			step, backup.Name, formatCommandLine(cmd.Args), err,
+		)
+	}
+
+	return err
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotProviderMapPath(t *testing.T) {
+	provider := SnapshotProvider{Type: SnapshotProviderBtrfs, Path: "/data", MountPath: "/data/.snapshots/rester"}
+
+	path, ok := provider.mapPath("/data/postgres")
+	assert.True(t, ok)
+	assert.Equal(t, "/data/.snapshots/rester/postgres", path)
+
+	path, ok = provider.mapPath("/data")
+	assert.True(t, ok)
+	assert.Equal(t, "/data/.snapshots/rester", path)
+
+	path, ok = provider.mapPath("/database")
+	assert.False(t, ok)
+	assert.Equal(t, "/database", path)
+
+	path, ok = provider.mapPath("*.tmp")
+	assert.False(t, ok)
+	assert.Equal(t, "*.tmp", path)
+
+	assert.Equal(
+		t, []string{"/data/postgres", "/other"},
+		provider.unmapPaths([]string{"/data/.snapshots/rester/postgres", "/other"}),
+	)
+	assert.Equal(t, []string{"/a"}, SnapshotProvider{}.unmapPaths([]string{"/a"}))
+}
+
+func TestSnapshotProviderCommands(t *testing.T) {
+	provider := SnapshotProvider{Type: SnapshotProviderLVM, Volume: "vg0/data", Size: "2G", MountPath: "/mnt/rester"}
+
+	assert.Equal(t, [][]string{
+		{"lvcreate", "--snapshot", "--size", "2G", "--name", "rester-db", "vg0/data"},
+		{"mkdir", "-p", "/mnt/rester"},
+		{"mount", "-o", "ro", "/dev/vg0/rester-db", "/mnt/rester"},
+	}, provider.createCommands("db"))
+
+	assert.Equal(t, [][]string{
+		{"umount", "/mnt/rester"},
+		{"lvremove", "--yes", "vg0/rester-db"},
+	}, provider.destroyCommands("db"))
+
+	provider = SnapshotProvider{Type: SnapshotProviderZFS, Volume: "tank/data"}
+	assert.Equal(t, [][]string{{"zfs", "snapshot", "tank/data@rester-db"}}, provider.createCommands("db"))
+	assert.Equal(t, [][]string{{"zfs", "destroy", "tank/data@rester-db"}}, provider.destroyCommands("db"))
+
+	provider = SnapshotProvider{Type: SnapshotProviderCustom, Create: "snap.sh"}
+	assert.Nil(t, provider.createCommands("db"))
+}
+
+func TestCreateFilesystemSnapshotUndoesCreatedSteps(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fake lvm commands are shell scripts")
+	}
+
+	dir, err := ioutil.TempDir("", "rester")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// lvcreate succeeds, mount fails
+	for name, code := range map[string]int{"lvcreate": 0, "mkdir": 0, "mount": 1} {
+		script := fmt.Sprintf("#!/bin/sh\nexit %d\n", code)
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755))
+	}
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir)
+
+	r := NewRestic("restic", ResticOptions{})
+	backup := Backup{Name: "db", SnapshotProvider: SnapshotProvider{
+		Type: SnapshotProviderLVM, Volume: "vg0/data", Size: "2G", MountPath: "/mnt/rester",
+	}}
+
+	undo, err := r.createFilesystemSnapshot(backup, nil)
+	assert.Error(t, err)
+	assert.Len(t, undo, 1)
+	assert.Equal(t, []string{"lvremove", "--yes", "vg0/rester-db"}, undo[0].Args)
+
+	// a custom destroy only runs once create has started
+	backup.SnapshotProvider = SnapshotProvider{Type: SnapshotProviderCustom, Create: "mount", Destroy: "lvremove"}
+	undo, err = r.createFilesystemSnapshot(backup, nil)
+	assert.Error(t, err)
+	assert.Len(t, undo, 1)
+
+	backup.SnapshotProvider.Create = "'unterminated"
+	undo, err = r.createFilesystemSnapshot(backup, nil)
+	assert.Error(t, err)
+	assert.Empty(t, undo)
+}
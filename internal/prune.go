@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// args returns the arguments passed to restic's prune.
+func (p Prune) args() []string {
+	var args []string
+
+	if p.MaxUnused != "" {
+		args = append(args, "--max-unused", p.MaxUnused)
+	}
+
+	if p.MaxRepackSize != "" {
+		args = append(args, "--max-repack-size", p.MaxRepackSize)
+	}
+
+	if p.RepackCacheableOnly {
+		args = append(args, "--repack-cacheable-only")
+	}
+
+	return args
+}
+
+// LastPrune returns the time the repository has been pruned successfully by
+// rester the last time or the zero time if unknown.
+func (r Restic) LastPrune(repository Repository) (time.Time, error) {
+	pruned := make(map[string]time.Time)
+
+	if err := readStateFile(r.stateDirectory, pruneFile, &pruned); err != nil {
+		return time.Time{}, err
+	}
+
+	return pruned[repository.Name], nil
+}
+
+// IsPruneDue reports whether the min_interval of the repository's prune
+// settings has passed since its last prune.
+func (r Restic) IsPruneDue(repository Repository) (bool, time.Time, error) {
+	lastPrune, err := r.LastPrune(repository)
+	if err != nil {
+		return true, lastPrune, err
+	}
+
+	if (lastPrune == time.Time{}) {
+		return true, lastPrune, nil
+	}
+
+	return time.Since(lastPrune) >= repository.Prune.MinInterval.Duration, lastPrune, nil
+}
+
+func (r Restic) RunPrune(repository Repository) error {
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		r.runHandlerPruneFailure(repository, err)
+		return err
+	}
+
+	cmd := r.prepareResticCommand(repository, make(map[string]string))
+	cmd.Args = append(cmd.Args, "prune")
+	cmd.Args = append(cmd.Args, repository.Prune.args()...)
+
+	r.streamOutput(cmd)
+
+	err := r.runRestic(fmt.Sprintf("prune repository [%s]", repository.Name), cmd)
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to prune repository [%s]: %s\n",
+			repository.Name,
+			err,
+		)
+		r.runHandlerPruneFailure(repository, err)
+		return err
+	}
+
+	if !r.dryRun {
+		if err := r.recordPrune(repository, time.Now()); err != nil {
+			fmt.Fprintf(
+				os.Stderr, "Failed to record prune of repository [%s]: %s\n",
+				repository.Name,
+				err,
+			)
+		}
+	}
+
+	r.runHandler(repository.Handler.PruneSuccess, "prune_success", repository.Environment, newHandlerArgs(nil, &repository))
+
+	return nil
+}
+
+func (r Restic) recordPrune(repository Repository, pruned time.Time) error {
+	state := make(map[string]time.Time)
+
+	if err := readStateFile(r.stateDirectory, pruneFile, &state); err != nil {
+		return err
+	}
+
+	state[repository.Name] = pruned
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(r.stateDirectory, pruneFile, data)
+}
+
+func (r Restic) runHandlerPruneFailure(repository Repository, err error) {
+	r.runHandler(repository.Handler.PruneFailure, "prune_failure", repository.Environment, newHandlerArgs(nil, &repository).withError(err))
+}
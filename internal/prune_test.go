@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	jsonutil "github.com/vrischmann/jsonutil"
+)
+
+func TestPruneArgs(t *testing.T) {
+	assert.Empty(t, Prune{}.args())
+
+	prune := Prune{MaxUnused: "5%", MaxRepackSize: "2G", RepackCacheableOnly: true}
+	assert.Equal(t, []string{
+		"--max-unused", "5%", "--max-repack-size", "2G", "--repack-cacheable-only",
+	}, prune.args())
+}
+
+func TestIsPruneDue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester-state-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := NewRestic("restic", ResticOptions{StateDirectory: dir})
+
+	repository := Repository{Name: "local"}
+	repository.Prune.MinInterval = jsonutil.FromDuration(24 * time.Hour)
+
+	due, _, err := r.IsPruneDue(repository)
+	assert.NoError(t, err)
+	assert.True(t, due)
+
+	assert.NoError(t, r.recordPrune(repository, time.Now().Add(-time.Hour)))
+
+	due, lastPrune, err := r.IsPruneDue(repository)
+	assert.NoError(t, err)
+	assert.False(t, due)
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), lastPrune, time.Minute)
+
+	assert.NoError(t, r.recordPrune(repository, time.Now().Add(-25*time.Hour)))
+
+	due, _, err = r.IsPruneDue(repository)
+	assert.NoError(t, err)
+	assert.True(t, due)
+
+	// without min_interval each run prunes
+	due, _, err = r.IsPruneDue(Repository{Name: "local"})
+	assert.NoError(t, err)
+	assert.True(t, due)
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+)
+
+// Rehost moves the snapshots of the backup saved for fromHost to the host of
+// the backup e.g. after renaming the machine. The snapshots are rewritten
+// using "restic rewrite --new-host" which needs restic 0.17 or newer. The
+// original snapshots are forgotten.
+func (r Restic) Rehost(backup Backup, repository Repository, fromHost string) error {
+
+	host, err := backup.GetHost()
+	if err != nil {
+		return err
+	}
+
+	if host == fromHost {
+		return fmt.Errorf("snapshots of backup [%s] already belong to host %s", backup.Name, host)
+	}
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		return err
+	}
+
+	snapshots, err := r.listSnapshots(repository, backup.Environment)
+	if err != nil {
+		return err
+	}
+
+	var snapshotIDs []string
+
+	if r.dryRun {
+		snapshotIDs = []string{dryRunSnapshotID}
+	}
+
+	for _, snapshot := range backupSnapshots(backup, snapshots, fromHost) {
+		snapshotIDs = append(snapshotIDs, snapshot.ID)
+	}
+
+	if len(snapshotIDs) == 0 {
+		fmt.Fprintf(
+			os.Stderr, "No snapshots of backup [%s] of host %s found in repository [%s]\n",
+			backup.Name, fromHost, repository.Name,
+		)
+		return nil
+	}
+
+	// restic's filters would also select snapshots of other backups with
+	// more paths, so the snapshots are passed explicitly
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+	cmd.Args = append(cmd.Args, "rewrite", "--forget", "--new-host", host)
+	cmd.Args = append(cmd.Args, snapshotIDs...)
+
+	r.streamOutput(cmd)
+
+	description := fmt.Sprintf(
+		"rehost %d snapshots of backup [%s] in repository [%s] from %s to %s",
+		len(snapshotIDs), backup.Name, repository.Name, fromHost, host,
+	)
+
+	return r.runRestic(description, cmd)
+}
+
+// backupSnapshots returns all snapshots saved by the backup for hostname
+// including incomplete ones.
+func backupSnapshots(backup Backup, snapshots []Snapshot, hostname string) []Snapshot {
+
+	matchers := []func(paths []string, tagged bool) bool{matchDataPaths(backup)}
+
+	if sources := backup.GetStdinSources(); len(sources) > 0 {
+		matchers = nil
+		for _, source := range sources {
+			matchers = append(matchers, matchSourcePaths(source))
+		}
+	}
+
+	var result []Snapshot
+
+	for _, snapshot := range snapshots {
+		for _, matchPaths := range matchers {
+			if isBackupSnapshot(snapshot, backup, hostname, matchPaths) {
+				result = append(result, snapshot)
+				break
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupSnapshots(t *testing.T) {
+	snapshots := []Snapshot{
+		{ID: "home", Hostname: "old", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+		{ID: "untagged", Hostname: "old", Paths: []string{"/home"}},
+		{ID: "superset", Hostname: "old", Paths: []string{"/etc", "/home"}},
+		{ID: "other", Hostname: "old", Paths: []string{"/home"}, Tags: []string{"rester:other"}},
+		{ID: "files", Hostname: "old", Paths: []string{"/srv/a", "/srv/b"}, Tags: []string{"rester:files"}},
+		{ID: "new", Hostname: "new", Paths: []string{"/home"}, Tags: []string{"rester:home"}},
+		{ID: "dump", Hostname: "old", Paths: []string{"/pg.sql"}, Tags: []string{"rester:db"}},
+	}
+
+	// snapshots of other backups are not selected even if they include the
+	// paths of the backup
+	backup := Backup{Name: "home", Data: []string{"/home"}}
+	assert.Equal(t, []string{"home", "untagged"}, ids(backupSnapshots(backup, snapshots, "old")))
+
+	// the paths of files_from backups are unknown, only tagged snapshots match
+	backup = Backup{Name: "files", FilesFrom: []string{"/files.txt"}}
+	assert.Equal(t, []string{"files"}, ids(backupSnapshots(backup, snapshots, "old")))
+
+	backup = Backup{Name: "db", StdinSources: []StdinSource{{Command: "pg_dump", Filename: "pg.sql"}}}
+	assert.Equal(t, []string{"dump"}, ids(backupSnapshots(backup, snapshots, "old")))
+}
+
+func TestBackupSnapshotsFromFilesystemSnapshot(t *testing.T) {
+	snapshots := []Snapshot{
+		{ID: "mapped", Hostname: "old", Paths: []string{"/data/.snapshots/rester/postgres"}},
+		{ID: "live", Hostname: "old", Paths: []string{"/data/other"}},
+	}
+
+	backup := Backup{
+		Name: "postgres", Data: []string{"/data/postgres"},
+		SnapshotProvider: SnapshotProvider{Type: SnapshotProviderBtrfs, Path: "/data", MountPath: "/data/.snapshots/rester"},
+	}
+	assert.Equal(t, []string{"mapped"}, ids(backupSnapshots(backup, snapshots, "old")))
+}
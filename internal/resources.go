@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+var ioniceClasses = map[string]string{
+	IONiceClassRealtime:   "1",
+	IONiceClassBestEffort: "2",
+	IONiceClassIdle:       "3",
+}
+
+// applyResources returns cmd running with the priority and resource limits
+// given. The priority is set by running cmd using nice and ionice, further
+// arguments appended to the returned command are still passed to cmd.
+func applyResources(cmd *exec.Cmd, resources Resources) *exec.Cmd {
+
+	if resources.GOMAXPROCS > 0 {
+		cmd.Env = append(cmd.Env, "GOMAXPROCS="+strconv.Itoa(resources.GOMAXPROCS))
+	}
+
+	if resources.ReadConcurrency > 0 {
+		cmd.Env = append(cmd.Env, "RESTIC_READ_CONCURRENCY="+strconv.Itoa(resources.ReadConcurrency))
+	}
+
+	if runtime.GOOS == "windows" {
+		return cmd
+	}
+
+	var prefix []string
+
+	if resources.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(resources.Nice))
+	}
+
+	if resources.IONiceClass != "" && runtime.GOOS == "linux" {
+		prefix = append(prefix, "ionice", "-c", ioniceClasses[resources.IONiceClass])
+		if resources.IONiceLevel != 0 {
+			prefix = append(prefix, "-n", strconv.Itoa(resources.IONiceLevel))
+		}
+	}
+
+	if len(prefix) == 0 {
+		return cmd
+	}
+
+	wrapped := exec.Command(prefix[0], append(prefix[1:], cmd.Args...)...)
+	wrapped.Env = cmd.Env
+	wrapped.Dir = cmd.Dir
+
+	return wrapped
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyResources(t *testing.T) {
+	cmd := applyResources(exec.Command("restic"), Resources{})
+	assert.Equal(t, []string{"restic"}, cmd.Args)
+
+	cmd = applyResources(exec.Command("restic", "backup"), Resources{
+		Nice:            10,
+		IONiceClass:     IONiceClassBestEffort,
+		IONiceLevel:     7,
+		GOMAXPROCS:      2,
+		ReadConcurrency: 1,
+	})
+	cmd.Args = append(cmd.Args, "/home")
+
+	assert.Equal(t, []string{"nice", "-n", "10", "ionice", "-c", "2", "-n", "7", "restic", "backup", "/home"}, cmd.Args)
+	assert.Contains(t, cmd.Env, "GOMAXPROCS=2")
+	assert.Contains(t, cmd.Env, "RESTIC_READ_CONCURRENCY=1")
+
+	cmd = applyResources(exec.Command("pg_dump"), Resources{IONiceClass: IONiceClassIdle})
+	assert.Equal(t, []string{"ionice", "-c", "3", "pg_dump"}, cmd.Args)
+}
+
+func TestOverrideRepository(t *testing.T) {
+	repository := Repository{}
+	repository.LimitUpload = 100
+	repository.Resources = Resources{Nice: 5, IONiceClass: IONiceClassIdle, GOMAXPROCS: 4}
+
+	backup := Backup{LimitDownload: 50, Resources: Resources{Nice: 15, IONiceClass: IONiceClassBestEffort, IONiceLevel: 6}}
+
+	overridden := backup.overrideRepository(repository)
+	assert.Equal(t, 50, overridden.LimitDownload)
+	assert.Equal(t, 100, overridden.LimitUpload)
+	assert.Equal(t, Resources{Nice: 15, IONiceClass: IONiceClassBestEffort, IONiceLevel: 6, GOMAXPROCS: 4}, overridden.Resources)
+	assert.Equal(t, 5, repository.Resources.Nice)
+}
@@ -4,36 +4,85 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
-	"math"
-	"math/rand"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	shlex "github.com/anmitsu/go-shlex"
 	homedir "github.com/mitchellh/go-homedir"
 )
 
+// IncompleteTag marks snapshots whose stdin command failed.
+const IncompleteTag = "rester:incomplete"
+
+// BackupTagPrefix is the prefix of the tag added to each snapshot to identify
+// the backup which created it e.g. "rester:home".
+const BackupTagPrefix = "rester:"
+
+func backupTag(backupName string) string {
+	return BackupTagPrefix + backupName
+}
+
+func hasBackupTag(tags []string) bool {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, BackupTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
 type Restic struct {
 	resticExecutable string
+	dryRun           bool
+	verbosity        int
+	interrupted      func() bool
+	stateDirectory   string
+	// noLock runs restic without locking the repository for read-only previews
+	noLock bool
+}
+
+type ResticOptions struct {
+	// DryRun prints all commands instead of executing them
+	DryRun bool
+	// Verbosity of 1 streams restic's output, 2 and above also logs each step
+	Verbosity int
+	// Interrupted reports whether rester should stop after the current command
+	Interrupted func() bool
+	// StateDirectory keeps checksums and other state between runs
+	StateDirectory string
 }
 
-func NewRestic(resticExecutable string) Restic {
+func NewRestic(resticExecutable string, options ResticOptions) Restic {
 	r := Restic{
 		resticExecutable: resticExecutable,
+		dryRun:           options.DryRun,
+		verbosity:        options.Verbosity,
+		interrupted:      options.Interrupted,
+		stateDirectory:   options.StateDirectory,
 	}
 	return r
 }
 
+func (r Restic) isInterrupted() bool {
+	return r.interrupted != nil && r.interrupted()
+}
+
 func (r Restic) IsResticAvailable() bool {
 
-	cmd := r.resticExecutable
-	args := []string{"version"}
+	cmd := exec.Command(r.resticExecutable, "version")
+
+	if r.dryRun {
+		printDryRun("check restic availability", cmd)
+		return true
+	}
+
+	r.logStep("check restic availability: %s", formatCommandLine(cmd.Args))
 
-	_, err := exec.Command(cmd, args...).Output()
+	_, err := cmd.Output()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return false
@@ -47,244 +96,12 @@ func (r Restic) IsRepositoryAvailable(repository Repository) error {
 	cmd := r.prepareResticCommand(repository, make(map[string]string))
 	cmd.Args = append(cmd.Args, "snapshots")
 
-	return cmd.Run()
-}
-
-func (r Restic) RunBackup(backup Backup, repository Repository) error {
-
-	environment := combineMaps(repository.Environment, backup.Environment)
-
-	if err := r.IsRepositoryAvailable(repository); err != nil {
-		runHandlerBackupFailure(backup, repository, environment)
-		return err
-	}
-
-	runHandler(backup.Handler.Before, "before", environment, &backup, &repository)
-
-	if err := r.runUnlock(repository); err != nil {
-		r.dumpUnlockError(repository, err)
-		runHandlerBackupFailure(backup, repository, environment)
-		return err
-	}
-
-	cmd := r.prepareResticCommand(repository, backup.Environment)
-	cmd.Args = append(cmd.Args, "backup")
-
-	cmd.Args = append(cmd.Args, backup.Data...)
-
-	for _, exclude := range backup.Exclude {
-		cmd.Args = append(cmd.Args, fmt.Sprintf("--exclude=%s", exclude))
-	}
-
-	for _, tag := range backup.Tags {
-		cmd.Args = append(cmd.Args, "--tag", tag)
-	}
-
-	if backup.OneFileSystem {
-		cmd.Args = append(cmd.Args, "--one-file-system")
-	}
-
-	for _, flag := range backup.CustomFlags {
-		cmd.Args = append(cmd.Args, flag)
-	}
-
-	var cmdStdin *exec.Cmd
-	var pr, pw *os.File
-
-	if backup.DataStdinCommand != "" {
-
-		cmd.Args = append(cmd.Args, "--stdin", "--stdin-filename", backup.StdinFilename)
-
-		var err error
-
-		cmdStdin, err = prepareShellCommand(backup.DataStdinCommand, environment)
-		if err != nil {
-			fmt.Fprintf(
-				os.Stderr, "Failed to prepare stdin shell command \"%s\": %s\n",
-				backup.DataStdinCommand, err,
-			)
-			return err
-		}
-
-		pr, pw, err = os.Pipe()
-
-		if err != nil {
-			fmt.Fprintf(
-				os.Stderr, "Failed to create pipe: %s\n",
-				err,
-			)
-			return err
-		}
-
-		cmdStdin.Stdout = pw
-		cmdStdin.Stderr = os.Stderr
-
-		cmd.Stdin = pr
-	}
-
-	/*
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Args = append(cmd.Args, "--verbose=5")
-
-		fmt.Println(cmd)
-	*/
-
-	if cmdStdin != nil {
-		err := cmdStdin.Start()
-
-		if err != nil {
-			fmt.Fprintf(
-				os.Stderr, "Failed to run stdin command: %s\n",
-				err,
-			)
-			return err
-		}
-	}
-
-	err := cmd.Start()
-	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to run restic command: %s\n",
-			err,
-		)
-		return err
-	}
-
-	if cmdStdin != nil {
-		err = cmdStdin.Wait()
-		pw.Close()
-
-		if err != nil {
-			fmt.Fprintf(
-				os.Stderr, "Failed to wait for stdin command: %s\n",
-				err,
-			)
-			return err
-		}
-	}
-
-	err = cmd.Wait()
-	pr.Close()
-
-	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to wait for restic command: %s\n",
-			err,
-		)
-		return err
-	}
-
-	runHandler(backup.Handler.After, "after", environment, &backup, &repository)
-
-	if err != nil {
-		runHandlerBackupFailure(backup, repository, environment)
-	} else {
-		runHandler(backup.Handler.Success, "success", environment, &backup, &repository)
-	}
-
-	return err
-}
-
-func (r Restic) RunCheck(repository Repository) error {
-
-	if err := r.runUnlock(repository); err != nil {
-		r.dumpUnlockError(repository, err)
-		runHandlerCheckFailure(repository)
-		return err
-	}
-
-	cmd := r.prepareResticCommand(repository, make(map[string]string))
-	cmd.Args = append(cmd.Args, "check")
-
-	if repository.Check.ReadDataPercentage >= 100 {
-		cmd.Args = append(cmd.Args, "--read-data")
-	} else if repository.Check.ReadDataPercentage > 0 {
-		subsets := int(math.Ceil(100.0 / float64(repository.Check.ReadDataPercentage)))
-		rand.Seed(time.Now().UTC().UnixNano())
-		subsetToCheck := rand.Intn(subsets) + 1
-
-		cmd.Args = append(cmd.Args, fmt.Sprintf("--read-data-subset=%d/%d", subsetToCheck, subsets))
-	}
-
-	err := cmd.Run()
-	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to check repository [%s]: %s\n",
-			repository.Name,
-			err,
-		)
-		runHandlerCheckFailure(repository)
-		return err
-	} else {
-		runHandler(repository.Handler.CheckSuccess, "check_success", repository.Environment, nil, &repository)
-	}
-
-	return nil
-}
-
-func (r Restic) RunForget(repository Repository) error {
-
-	if err := r.runUnlock(repository); err != nil {
-		r.dumpUnlockError(repository, err)
-		runHandlerForgetFailure(repository)
-		return err
-	}
-
-	cmd := r.prepareResticCommand(repository, make(map[string]string))
-	cmd.Args = append(cmd.Args, "forget", "--prune")
-
-	if repository.Policy.KeepLast > 0 {
-		cmd.Args = append(cmd.Args, "--keep-last", fmt.Sprint(repository.Policy.KeepLast))
-	}
-
-	if repository.Policy.KeepHourly > 0 {
-		cmd.Args = append(cmd.Args, "--keep-hourly", fmt.Sprint(repository.Policy.KeepHourly))
-	}
-
-	if repository.Policy.KeepDaily > 0 {
-		cmd.Args = append(cmd.Args, "--keep-daily", fmt.Sprint(repository.Policy.KeepDaily))
-	}
-
-	if repository.Policy.KeepWeekly > 0 {
-		cmd.Args = append(cmd.Args, "--keep-weekly", fmt.Sprint(repository.Policy.KeepWeekly))
-	}
-
-	if repository.Policy.KeepMonthly > 0 {
-		cmd.Args = append(cmd.Args, "--keep-monthly", fmt.Sprint(repository.Policy.KeepMonthly))
-	}
-
-	if repository.Policy.KeepYearly > 0 {
-		cmd.Args = append(cmd.Args, "--keep-yearly", fmt.Sprint(repository.Policy.KeepYearly))
-	}
-
-	if repository.Policy.KeepWithin != "" {
-		cmd.Args = append(cmd.Args, "--keep-within", fmt.Sprint(repository.Policy.KeepWithin))
-	}
-
-	for _, tag := range repository.Policy.KeepTags {
-		cmd.Args = append(cmd.Args, "--keep-tag", tag)
-	}
-
-	err := cmd.Run()
-	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to forget for repository [%s]: %s\n",
-			repository.Name,
-			err,
-		)
-		runHandlerForgetFailure(repository)
-		return err
-	} else {
-		runHandler(repository.Handler.ForgetSuccess, "forget_success", repository.Environment, nil, &repository)
-	}
-
-	return nil
+	return r.runRestic(fmt.Sprintf("check availability of repository [%s]", repository.Name), cmd)
 }
 
 func (r Restic) PrintSnapshots(repository Repository) error {
 
-	if err := r.runUnlock(repository); err != nil {
+	if err := r.prepareLocks(repository); err != nil {
 		r.dumpUnlockError(repository, err)
 		return err
 	}
@@ -292,9 +109,14 @@ func (r Restic) PrintSnapshots(repository Repository) error {
 	cmd := r.prepareResticCommand(repository, make(map[string]string))
 	cmd.Args = append(cmd.Args, "snapshots")
 
+	if r.dryRun {
+		printDryRun(fmt.Sprintf("list snapshots of repository [%s]", repository.Name), cmd)
+		return nil
+	}
+
 	cmdOut, err := cmd.Output()
 	if err != nil {
-		return err
+		return resticError(err, "")
 	}
 
 	fmt.Printf("Snapshots for %s (%s):\n\n", repository.Name, repository.URL)
@@ -309,7 +131,7 @@ func (r Restic) Mount(repository Repository, mountPoint string) error {
 		return fmt.Errorf("%s is not a directory", mountPoint)
 	}
 
-	if err := r.runUnlock(repository); err != nil {
+	if err := r.prepareLocks(repository); err != nil {
 		r.dumpUnlockError(repository, err)
 		return err
 	}
@@ -321,7 +143,7 @@ func (r Restic) Mount(repository Repository, mountPoint string) error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Run()
+	err := r.runRestic(fmt.Sprintf("mount repository [%s]", repository.Name), cmd)
 	if err != nil {
 		return err
 	}
@@ -329,25 +151,34 @@ func (r Restic) Mount(repository Repository, mountPoint string) error {
 	return nil
 }
 
-func (r Restic) Init(repository Repository) error {
+// Init initializes the repository. If chunkerSource is given the chunker
+// parameters are copied from it so snapshots copied from there deduplicate.
+func (r Restic) Init(repository Repository, chunkerSource *Repository) error {
 
-	cmd := r.prepareResticCommand(repository, make(map[string]string))
-	cmd.Args = append(cmd.Args, "init")
+	var cmd *exec.Cmd
+
+	if chunkerSource != nil {
+		cmd = r.prepareCopyCommand("init", *chunkerSource, repository, make(map[string]string))
+		cmd.Args = append(cmd.Args, "--copy-chunker-params")
+	} else {
+		cmd = r.prepareResticCommand(repository, make(map[string]string))
+		cmd.Args = append(cmd.Args, "init")
+	}
 
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	return cmd.Run()
+	return r.runRestic(fmt.Sprintf("initialize repository [%s]", repository.Name), cmd)
 }
 
 func (r Restic) CheckAge(backup Backup, repository Repository) (bool, bool, error) {
 
 	environment := combineMaps(repository.Environment, backup.Environment)
 
-	if err := r.runUnlock(repository); err != nil {
+	if err := r.prepareLocks(repository); err != nil {
 		r.dumpUnlockError(repository, err)
-		runHandlerAgeError(backup, repository, environment)
+		r.runHandlerAgeError(backup, repository, environment)
 		return false, false, err
 	}
 
@@ -357,16 +188,20 @@ func (r Restic) CheckAge(backup Backup, repository Repository) (bool, bool, erro
 		return false, false, err
 	}
 
+	if r.dryRun {
+		return false, false, nil
+	}
+
 	if (lastBackupTimestamp == time.Time{}) {
 		return false, true, nil
 	} else {
 		age := time.Now().Sub(lastBackupTimestamp)
 
 		if age > backup.Age.Error.Duration {
-			runHandlerAgeError(backup, repository, environment)
+			r.runHandlerAgeError(backup, repository, environment)
 			return false, true, nil
 		} else if age > backup.Age.Warn.Duration {
-			runHandler(backup.Handler.AgeWarn, "age_warn", environment, &backup, &repository)
+			r.runHandler(backup.Handler.AgeWarn, "age_warn", environment, newHandlerArgs(&backup, &repository))
 			return true, false, nil
 		}
 	}
@@ -376,80 +211,215 @@ func (r Restic) CheckAge(backup Backup, repository Repository) (bool, bool, erro
 
 func (r Restic) GetLastBackupTimestamp(backup Backup, repository Repository) (time.Time, error) {
 
-	cmd := r.prepareResticCommand(repository, backup.Environment)
+	snapshots, err := r.listSnapshots(repository, backup.Environment)
+	if err != nil || r.dryRun {
+		return time.Time{}, err
+	}
+
+	hostname, err := backup.GetHost()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get hostname: %s\n", err)
+	}
+
+	return latestBackupTimestamp(backup, snapshots, hostname), nil
+}
+
+// GetSnapshots returns the snapshots in the repository. If a backup is given
+// only its snapshots are returned identified by its host and backup tag like
+// forget does.
+func (r Restic) GetSnapshots(repository Repository, backup *Backup) ([]Snapshot, error) {
+
+	if backup == nil {
+		return r.listSnapshots(repository, make(map[string]string))
+	}
+
+	hostname, err := backup.GetHost()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := r.listSnapshots(repository, backup.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.Hostname == hostname && Contains(snapshot.Tags, backupTag(backup.Name)) {
+			result = append(result, snapshot)
+		}
+	}
+
+	return result, nil
+}
+
+// listSnapshots returns all snapshots of the repository. In dry-run mode the
+// command is only printed and no snapshots are returned.
+func (r Restic) listSnapshots(repository Repository, environment map[string]string) ([]Snapshot, error) {
+
+	cmd := r.prepareResticCommand(repository, environment)
 	cmd.Args = append(cmd.Args, "snapshots", "--json")
 
-	var output bytes.Buffer
+	if r.dryRun {
+		printDryRun(fmt.Sprintf("list snapshots in repository [%s]", repository.Name), cmd)
+		return nil, nil
+	}
+
+	var output, stderr bytes.Buffer
 	cmd.Stdout = &output
+	cmd.Stderr = &stderr
 
-	err := cmd.Run()
+	err := resticError(cmd.Run(), stderr.String())
 	if err != nil {
 		fmt.Fprintf(
-			os.Stderr, "Failed to get age for backup [%s] in repository [%s]: %s\n",
-			backup.Name,
+			os.Stderr, "Failed to list snapshots in repository [%s]: %s\n",
 			repository.Name,
 			err,
 		)
-		return time.Time{}, err
-	}
-
-	type Snapshot struct {
-		Time     time.Time
-		Hostname string
-		Paths    []string
-		Tags     []string
+		return nil, err
 	}
 
 	var snapshots []Snapshot
 
 	if err := json.Unmarshal(output.Bytes(), &snapshots); err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to get hostname: %s\n", err)
+	return snapshots, nil
+}
+
+// Snapshot is a snapshot as listed by "restic snapshots --json". Original is
+// the ID of the snapshot it has been copied from.
+type Snapshot struct {
+	ID       string    `json:"id"`
+	Original string    `json:"original,omitempty"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Paths    []string  `json:"paths"`
+	Tags     []string  `json:"tags"`
+}
+
+// BackupName returns the name of the backup which created the snapshot
+// according to its backup tag or an empty string.
+func (s Snapshot) BackupName() string {
+	for _, tag := range s.Tags {
+		if strings.HasPrefix(tag, BackupTagPrefix) && tag != IncompleteTag {
+			return strings.TrimPrefix(tag, BackupTagPrefix)
+		}
+	}
+	return ""
+}
+
+// latestBackupTimestamp returns the time of the latest snapshot of backup. A
+// backup with multiple stdin sources is only as recent as its oldest source,
+// so the result is zero if any source has no snapshot yet.
+func latestBackupTimestamp(backup Backup, snapshots []Snapshot, hostname string) time.Time {
+
+	sources := backup.GetStdinSources()
+
+	if len(sources) == 0 {
+		if latest := latestDataSnapshot(backup, snapshots, hostname); latest != nil {
+			return latest.Time
+		}
+		return time.Time{}
+	}
+
+	oldest := time.Time{}
+	for i, source := range sources {
+		latest := latestSourceSnapshot(backup, source, snapshots, hostname)
+
+		if latest == nil {
+			return time.Time{}
+		}
+
+		if i == 0 || latest.Time.Before(oldest) {
+			oldest = latest.Time
+		}
+	}
+
+	return oldest
+}
+
+// latestDataSnapshot returns the latest snapshot of a backup of files or nil.
+func latestDataSnapshot(backup Backup, snapshots []Snapshot, hostname string) *Snapshot {
+	return latestSnapshot(snapshots, backup, hostname, matchDataPaths(backup))
+}
+
+// latestSourceSnapshot returns the latest snapshot of a stdin source of a
+// backup or nil.
+func latestSourceSnapshot(backup Backup, source StdinSource, snapshots []Snapshot, hostname string) *Snapshot {
+	return latestSnapshot(snapshots, backup, hostname, matchSourcePaths(source))
+}
+
+// matchDataPaths matches the paths of snapshots of a backup of files.
+func matchDataPaths(backup Backup) func(paths []string, tagged bool) bool {
+	return func(paths []string, tagged bool) bool {
+		// tagged snapshots belong to the backup even if its data changed, the
+		// paths read from files are unknown so only tagged snapshots match
+		if tagged || backup.HasFilesFrom() {
+			return tagged
+		}
+		return comparePathList(backup.SnapshotProvider.unmapPaths(paths), backup.Data)
 	}
+}
 
-	lastBackupTimestamp := time.Time{}
-	for _, s := range snapshots {
-
-		if backup.DataStdinCommand != "" {
-			if len(s.Paths) != 1 {
-				continue
-			}
-			if !strings.HasSuffix(s.Paths[0], backup.StdinFilename) {
-				continue
-			}
-		} else {
-			if !comparePathList(s.Paths, backup.Data) {
-				continue
-			}
+// matchSourcePaths matches the paths of snapshots of a stdin source.
+func matchSourcePaths(source StdinSource) func(paths []string, tagged bool) bool {
+	return func(paths []string, tagged bool) bool {
+		return len(paths) == 1 && strings.HasSuffix(paths[0], source.Filename)
+	}
+}
+
+// latestSnapshot returns the latest snapshot of backup whose paths match or
+// nil. Incomplete snapshots are ignored.
+func latestSnapshot(
+	snapshots []Snapshot, backup Backup, hostname string, matchPaths func(paths []string, tagged bool) bool,
+) *Snapshot {
+
+	var latest *Snapshot
+	for i, s := range snapshots {
+
+		if Contains(s.Tags, IncompleteTag) {
+			continue
 		}
 
-		if s.Hostname != hostname || !compareStringList(s.Tags, backup.Tags) {
+		if !isBackupSnapshot(s, backup, hostname, matchPaths) {
 			continue
 		}
 
-		if s.Time.After(lastBackupTimestamp) {
-			lastBackupTimestamp = s.Time
+		if latest == nil || s.Time.After(latest.Time) {
+			latest = &snapshots[i]
 		}
 	}
 
-	return lastBackupTimestamp, nil
+	return latest
 }
 
-func (r Restic) runUnlock(repository Repository) error {
+// isBackupSnapshot reports whether the snapshot has been saved by backup for
+// hostname. Snapshots are identified by the backup tag. Older snapshots
+// created without that tag are matched by the tags and paths of the backup
+// instead.
+func isBackupSnapshot(
+	s Snapshot, backup Backup, hostname string, matchPaths func(paths []string, tagged bool) bool,
+) bool {
 
-	cmd := r.prepareResticCommand(repository, make(map[string]string))
-	cmd.Args = append(cmd.Args, "unlock")
+	if s.Hostname != hostname {
+		return false
+	}
 
-	return cmd.Run()
+	tagged := Contains(s.Tags, backupTag(backup.Name))
+
+	if !tagged && (hasBackupTag(s.Tags) || !compareStringList(s.Tags, backup.Tags)) {
+		// created by another backup or without the tag by a different one
+		return false
+	}
+
+	return matchPaths(s.Paths, tagged)
 }
 
 func (r Restic) dumpUnlockError(repository Repository, err error) {
 	fmt.Fprintf(
-		os.Stderr, "Failed to unlock repository [%s]: %s\n",
+		os.Stderr, "Failed to handle locks of repository [%s]: %s\n",
 		repository.Name,
 		err,
 	)
@@ -459,15 +429,23 @@ func (r Restic) prepareResticCommand(
 	repo Repository, additionalEnvironment map[string]string,
 ) *exec.Cmd {
 	environment := combineMaps(repo.Environment, additionalEnvironment)
-	return r.PrepareResticEnvironmentCommand(
+	cmd := r.PrepareResticEnvironmentCommand(
 		r.resticExecutable, repo.URL, repo.Password, environment,
-		repo.LimitDownload, repo.LimitUpload, repo.CustomFlags,
+		repo.LimitDownload, repo.LimitUpload, repo.CustomFlags, repo.Resources,
 	)
+
+	if r.noLock {
+		cmd.Args = append(cmd.Args, "--no-lock")
+	} else if repo.Lock.Strategy == LockStrategyWait {
+		cmd.Args = append(cmd.Args, "--retry-lock", repo.Lock.WaitTimeout.String())
+	}
+
+	return cmd
 }
 
 func (r Restic) PrepareResticEnvironmentCommand(
 	command string, repoURL string, password string, environment map[string]string,
-	limitDownload int, limitUpload int, customFlags []string,
+	limitDownload int, limitUpload int, customFlags []string, resources Resources,
 ) *exec.Cmd {
 	cmd := exec.Command(command)
 
@@ -489,24 +467,137 @@ func (r Restic) PrepareResticEnvironmentCommand(
 	cmd.Env = append(cmd.Env, fmt.Sprintf("RESTIC_REPOSITORY=%s", repoURL))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("RESTIC_PASSWORD=%s", password))
 
-	return cmd
+	return applyResources(cmd, resources)
+}
+
+// handlerArgs are available as template variables inside handler commands
+// and as RESTER_* environment variables of the handler.
+type handlerArgs struct {
+	BackupName          string
+	RepositoryName      string
+	RepositoryURL       string
+	SnapshotID          string
+	FilesNew            uint64
+	FilesChanged        uint64
+	DataAdded           uint64
+	TotalBytesProcessed uint64
+	Duration            time.Duration
+	Error               string
+	hasSummary          bool
+}
+
+func newHandlerArgs(backup *Backup, repository *Repository) handlerArgs {
+	args := handlerArgs{}
+	if backup != nil {
+		args.BackupName = backup.Name
+	}
+	if repository != nil {
+		args.RepositoryName = repository.Name
+		args.RepositoryURL = repository.URL
+	}
+	return args
 }
 
-func runHandler(command string, handlerName string, environment map[string]string, backup *Backup, repository *Repository) {
+func (a handlerArgs) withSummary(summary *BackupSummary) handlerArgs {
+	if summary == nil {
+		return a
+	}
+	a.SnapshotID = summary.SnapshotID
+	a.FilesNew = summary.FilesNew
+	a.FilesChanged = summary.FilesChanged
+	a.DataAdded = summary.DataAdded
+	a.TotalBytesProcessed = summary.TotalBytesProcessed
+	a.Duration = time.Duration(summary.TotalDuration * float64(time.Second)).Round(time.Second)
+	a.hasSummary = true
+	return a
+}
+
+func (a handlerArgs) withError(err error) handlerArgs {
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+func (a handlerArgs) environment() map[string]string {
+	env := map[string]string{
+		"RESTER_BACKUP_NAME":     a.BackupName,
+		"RESTER_REPOSITORY_NAME": a.RepositoryName,
+		"RESTER_REPOSITORY_URL":  a.RepositoryURL,
+	}
+	if a.hasSummary {
+		env["RESTER_SNAPSHOT_ID"] = a.SnapshotID
+		env["RESTER_FILES_NEW"] = strconv.FormatUint(a.FilesNew, 10)
+		env["RESTER_FILES_CHANGED"] = strconv.FormatUint(a.FilesChanged, 10)
+		env["RESTER_DATA_ADDED"] = strconv.FormatUint(a.DataAdded, 10)
+		env["RESTER_TOTAL_BYTES_PROCESSED"] = strconv.FormatUint(a.TotalBytesProcessed, 10)
+		env["RESTER_DURATION"] = strconv.FormatInt(int64(a.Duration/time.Second), 10)
+	}
+	if a.Error != "" {
+		env["RESTER_ERROR"] = a.Error
+	}
+	return env
+}
+
+func (r Restic) runHandler(command string, handlerName string, environment map[string]string, args handlerArgs) {
 
 	if command == "" {
 		return
 	}
 
+	commandArgs, err := expandHandlerCommand(command, args)
+
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to run handler [%s] \"%s\": %s\n",
+			handlerName, command, err,
+		)
+		return
+	}
+
+	cmd := prepareCommand(commandArgs, combineMaps(environment, args.environment()), Resources{})
+
+	err = r.run(fmt.Sprintf("handler [%s]", handlerName), cmd)
+
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr, "Failed to run handler [%s] \"%s\": %s\n",
+			handlerName, formatCommandLine(commandArgs), err,
+		)
+	}
+}
+
+// expandHandlerCommand expands the template variables of the handler command
+// and splits it into its arguments. The values are inserted after splitting
+// so quotes or spaces e.g. in an error message end up in the argument as is.
+func expandHandlerCommand(command string, args handlerArgs) ([]string, error) {
+
 	commandToRun, err := homedir.Expand(command)
 	if err != nil {
 		fmt.Fprintf(
 			os.Stderr, "Failed to expand homedir in command: %s\n",
 			err,
 		)
+		commandToRun = command
+	}
+
+	var values []string
+	placeholder := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		values = append(values, value)
+		return fmt.Sprintf("\x00%d\x00", len(values)-1)
 	}
 
-	tmpl := template.New("cmd")
+	placeholderArgs := args
+	placeholderArgs.BackupName = placeholder(args.BackupName)
+	placeholderArgs.RepositoryName = placeholder(args.RepositoryName)
+	placeholderArgs.RepositoryURL = placeholder(args.RepositoryURL)
+	placeholderArgs.SnapshotID = placeholder(args.SnapshotID)
+	placeholderArgs.Error = placeholder(args.Error)
+
+	tmpl := template.New("cmd").Funcs(template.FuncMap{"bytes": formatBytes})
 	tmpl, err = tmpl.Parse(commandToRun)
 	if err != nil {
 		fmt.Fprintf(
@@ -514,63 +605,38 @@ func runHandler(command string, handlerName string, environment map[string]strin
 			err,
 		)
 	} else {
-
-		type TemplateArgs struct {
-			BackupName     string
-			RepositoryName string
-			RepositoryURL  string
-		}
-
-		args := TemplateArgs{}
-		if backup != nil {
-			args.BackupName = backup.Name
-		}
-		if repository != nil {
-			args.RepositoryName = repository.Name
-			args.RepositoryURL = repository.URL
-		}
-
 		var buffer bytes.Buffer
-		tmpl.Execute(&buffer, args)
+		tmpl.Execute(&buffer, placeholderArgs)
 		commandToRun = buffer.String()
 	}
 
-	cmd, err := prepareShellCommand(commandToRun, environment)
-
+	commandArgs, err := shlex.Split(commandToRun, true)
 	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to run handler [%s] \"%s\": %s\n",
-			handlerName, commandToRun, err,
-		)
+		return nil, err
 	}
 
-	err = cmd.Run()
-
-	if err != nil {
-		fmt.Fprintf(
-			os.Stderr, "Failed to run handler [%s] \"%s\": %s\n",
-			handlerName, commandToRun, err,
-		)
+	for i := range commandArgs {
+		for j, value := range values {
+			commandArgs[i] = strings.ReplaceAll(commandArgs[i], fmt.Sprintf("\x00%d\x00", j), value)
+		}
 	}
-}
 
-func runHandlerCheckFailure(repository Repository) {
-	runHandler(repository.Handler.CheckFailure, "check_failure", repository.Environment, nil, &repository)
+	return commandArgs, nil
 }
 
-func runHandlerBackupFailure(backup Backup, repository Repository, environment map[string]string) {
-	runHandler(backup.Handler.Failure, "failure", environment, &backup, &repository)
+func (r Restic) runHandlerBackupFailure(backup Backup, repository Repository, environment map[string]string, err error) {
+	r.runHandler(backup.Handler.Failure, "failure", environment, newHandlerArgs(&backup, &repository).withError(err))
 }
 
-func runHandlerForgetFailure(repository Repository) {
-	runHandler(repository.Handler.ForgetFailure, "forget_failure", repository.Environment, nil, &repository)
+func (r Restic) runHandlerForgetFailure(repository Repository, err error) {
+	r.runHandler(repository.Handler.ForgetFailure, "forget_failure", repository.Environment, newHandlerArgs(nil, &repository).withError(err))
 }
 
-func runHandlerAgeError(backup Backup, repository Repository, environment map[string]string) {
-	runHandler(backup.Handler.AgeError, "age_error", environment, &backup, &repository)
+func (r Restic) runHandlerAgeError(backup Backup, repository Repository, environment map[string]string) {
+	r.runHandler(backup.Handler.AgeError, "age_error", environment, newHandlerArgs(&backup, &repository))
 }
 
-func prepareShellCommand(command string, environment map[string]string) (*exec.Cmd, error) {
+func prepareShellCommand(command string, environment map[string]string, resources Resources) (*exec.Cmd, error) {
 
 	args, err := shlex.Split(command, true)
 
@@ -578,6 +644,12 @@ func prepareShellCommand(command string, environment map[string]string) (*exec.C
 		return nil, err
 	}
 
+	return prepareCommand(args, environment, resources), nil
+}
+
+// prepareCommand prepares a command already split into its arguments.
+func prepareCommand(args []string, environment map[string]string, resources Resources) *exec.Cmd {
+
 	args0 := ""
 	args1 := []string{}
 
@@ -595,7 +667,7 @@ func prepareShellCommand(command string, environment map[string]string) (*exec.C
 		convertEnvironment(environment)...,
 	)
 
-	return cmd, err
+	return applyResources(cmd, resources)
 }
 
 func convertEnvironment(env map[string]string) []string {
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandHandlerCommand(t *testing.T) {
+	backup := Backup{Name: "shop"}
+	repository := Repository{Name: "remote", URL: "/srv/backups/remote"}
+
+	args := newHandlerArgs(&backup, &repository)
+	command, err := expandHandlerCommand("notify.sh {{.BackupName}} {{.RepositoryName}} {{.RepositoryURL}}", args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"notify.sh", "shop", "remote", "/srv/backups/remote"}, command)
+
+	args = args.withSummary(&BackupSummary{
+		SnapshotID:          "0123456789abcdef",
+		FilesNew:            12,
+		FilesChanged:        3,
+		DataAdded:           120 * 1024 * 1024,
+		TotalBytesProcessed: 4096,
+		TotalDuration:       181.6,
+	})
+	command, err = expandHandlerCommand(
+		"notify.sh \"{{.BackupName}}: +{{bytes .DataAdded}} in {{.Duration}} "+
+			"({{.SnapshotID}}, {{.FilesNew}} new, {{.FilesChanged}} changed)\"",
+		args,
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"notify.sh", "shop: +120.0 MiB in 3m2s (0123456789abcdef, 12 new, 3 changed)"}, command)
+}
+
+func TestExpandHandlerCommandWithQuotes(t *testing.T) {
+	backup := Backup{Name: "shop"}
+	message := `trailing marker "-- Dump completed" not found for 'app' & <x>`
+	args := newHandlerArgs(&backup, nil).withError(errors.New(message))
+
+	for _, handler := range []string{
+		`notify.sh "{{.Error}}"`,
+		`notify.sh '{{.Error}}'`,
+		`notify.sh {{.Error}}`,
+	} {
+		command, err := expandHandlerCommand(handler, args)
+		assert.NoError(t, err, handler)
+		assert.Equal(t, []string{"notify.sh", message}, command, handler)
+	}
+
+	command, err := expandHandlerCommand(`sh -c "echo {{.BackupName}}: $RESTER_ERROR"`, args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", "echo shop: $RESTER_ERROR"}, command)
+}
+
+func TestHandlerArgsEnvironment(t *testing.T) {
+	backup := Backup{Name: "shop"}
+	repository := Repository{Name: "remote", URL: "/srv/backups/remote"}
+
+	env := newHandlerArgs(&backup, &repository).environment()
+	assert.Equal(t, "shop", env["RESTER_BACKUP_NAME"])
+	assert.Equal(t, "remote", env["RESTER_REPOSITORY_NAME"])
+	assert.Equal(t, "/srv/backups/remote", env["RESTER_REPOSITORY_URL"])
+	_, ok := env["RESTER_SNAPSHOT_ID"]
+	assert.False(t, ok)
+
+	args := newHandlerArgs(nil, &repository).withSummary(&BackupSummary{
+		SnapshotID:          "abc",
+		FilesNew:            1,
+		FilesChanged:        2,
+		DataAdded:           3,
+		TotalBytesProcessed: 4,
+		TotalDuration:       65,
+	})
+	assert.Equal(t, 65*time.Second, args.Duration)
+
+	env = args.environment()
+	assert.Equal(t, "", env["RESTER_BACKUP_NAME"])
+	assert.Equal(t, "abc", env["RESTER_SNAPSHOT_ID"])
+	assert.Equal(t, "1", env["RESTER_FILES_NEW"])
+	assert.Equal(t, "2", env["RESTER_FILES_CHANGED"])
+	assert.Equal(t, "3", env["RESTER_DATA_ADDED"])
+	assert.Equal(t, "4", env["RESTER_TOTAL_BYTES_PROCESSED"])
+	assert.Equal(t, "65", env["RESTER_DURATION"])
+}
+
+func TestHandlerArgsWithError(t *testing.T) {
+	args := newHandlerArgs(nil, nil).withError(nil)
+	assert.Equal(t, "", args.Error)
+	_, ok := args.environment()["RESTER_ERROR"]
+	assert.False(t, ok)
+
+	args = args.withError(errors.New("stdin command failed"))
+	assert.Equal(t, "stdin command failed", args.Error)
+	assert.Equal(t, "stdin command failed", args.environment()["RESTER_ERROR"])
+	command, err := expandHandlerCommand("failed: {{.Error}}", args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"failed:", "stdin command failed"}, command)
+}
+
+func TestLatestDataSnapshotWithFilesFrom(t *testing.T) {
+	now := time.Now()
+	snapshots := []Snapshot{
+		{ID: "tagged", Time: now.Add(-2 * time.Hour), Hostname: "box", Paths: []string{"/etc"}, Tags: []string{"rester:etc"}},
+		{ID: "untagged", Time: now.Add(-1 * time.Hour), Hostname: "box", Paths: []string{"/home"}},
+	}
+
+	backup := Backup{Name: "etc", FilesFrom: []string{"/etc/rester/files"}}
+
+	// snapshots without the backup tag might be of any backup of the host
+	latest := latestDataSnapshot(backup, snapshots, "box")
+	assert.NotNil(t, latest)
+	assert.Equal(t, "tagged", latest.ID)
+
+	assert.Nil(t, latestDataSnapshot(backup, snapshots[1:], "box"))
+
+	backup = Backup{Name: "home", Data: []string{"/home"}}
+	latest = latestDataSnapshot(backup, snapshots, "box")
+	assert.NotNil(t, latest)
+	assert.Equal(t, "untagged", latest.ID)
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// checksumsFile holds the checksums of the data read from stdin sources.
+const checksumsFile = "checksums.json"
+
+// pruneFile holds the time each repository has been pruned successfully.
+const pruneFile = "prune.json"
+
+// checkFile holds the progress of each repository through its read data
+// subsets.
+const checkFile = "check.json"
+
+// checksums older than the latest ones of each backup and stdin source are
+// only needed to verify snapshots not yet copied to all repositories
+const maxChecksumsPerSource = 10
+
+// defaultStateDirectory returns the directory rester keeps its state in
+// following the XDG base directory specification.
+func defaultStateDirectory() string {
+	if stateHome, isDefined := os.LookupEnv("XDG_STATE_HOME"); isDefined {
+		return filepath.Join(stateHome, "rester")
+	}
+
+	if runtime.GOOS == "windows" {
+		if cacheDir, err := os.UserCacheDir(); err == nil {
+			return filepath.Join(cacheDir, "rester", "state")
+		}
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".local", "state", "rester")
+}
+
+// checksumRecord is the checksum of the data a stdin source has written to
+// restic when creating a snapshot.
+type checksumRecord struct {
+	Backup   string    `json:"backup"`
+	Filename string    `json:"filename"`
+	SHA256   string    `json:"sha256"`
+	Time     time.Time `json:"time"`
+}
+
+func loadChecksums(stateDirectory string) (map[string]checksumRecord, error) {
+	checksums := make(map[string]checksumRecord)
+
+	if err := readStateFile(stateDirectory, checksumsFile, &checksums); err != nil {
+		return nil, err
+	}
+
+	return checksums, nil
+}
+
+// saveChecksum records the checksum for the snapshot with the given ID. Only
+// the latest checksums of each backup and stdin source are kept.
+func saveChecksum(stateDirectory string, snapshotID string, record checksumRecord) error {
+	checksums, err := loadChecksums(stateDirectory)
+	if err != nil {
+		return err
+	}
+
+	checksums[snapshotID] = record
+	pruneChecksums(checksums, maxChecksumsPerSource)
+
+	data, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeStateFile(stateDirectory, checksumsFile, data)
+}
+
+func pruneChecksums(checksums map[string]checksumRecord, keep int) {
+	bySource := make(map[string][]string)
+
+	for id, record := range checksums {
+		source := record.Backup + "/" + record.Filename
+		bySource[source] = append(bySource[source], id)
+	}
+
+	for _, ids := range bySource {
+		sort.Slice(ids, func(i, j int) bool {
+			return checksums[ids[i]].Time.After(checksums[ids[j]].Time)
+		})
+
+		if len(ids) <= keep {
+			continue
+		}
+
+		for _, id := range ids[keep:] {
+			delete(checksums, id)
+		}
+	}
+}
+
+// readStateFile reads JSON from a file inside the state directory into v. A
+// missing file leaves v untouched.
+func readStateFile(stateDirectory string, name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(stateDirectory, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// writeStateFile replaces a file inside the state directory atomically so an
+// interrupted rester never leaves a truncated file behind.
+func writeStateFile(stateDirectory string, name string, data []byte) error {
+	if err := os.MkdirAll(stateDirectory, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(stateDirectory, name+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(stateDirectory, name))
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester-state-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	checksums, err := loadChecksums(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, checksums)
+
+	record := checksumRecord{Backup: "db", Filename: "db.sql", SHA256: "abc", Time: time.Now()}
+	assert.NoError(t, saveChecksum(dir, "1234", record))
+
+	checksums, err = loadChecksums(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", checksums["1234"].SHA256)
+}
+
+func TestPruneChecksums(t *testing.T) {
+	now := time.Now()
+
+	checksums := map[string]checksumRecord{
+		"1": {Backup: "db", Filename: "db.sql", Time: now.Add(-3 * time.Hour)},
+		"2": {Backup: "db", Filename: "db.sql", Time: now.Add(-2 * time.Hour)},
+		"3": {Backup: "db", Filename: "db.sql", Time: now.Add(-1 * time.Hour)},
+		"4": {Backup: "db", Filename: "other.sql", Time: now.Add(-4 * time.Hour)},
+	}
+
+	pruneChecksums(checksums, 2)
+
+	assert.Len(t, checksums, 3)
+	assert.NotContains(t, checksums, "1")
+	assert.Contains(t, checksums, "4")
+}
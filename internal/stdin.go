@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// the trailing marker has to be found within the last bytes of the stream
+const trailingMarkerWindow = 4096
+
+// validatorQueueSize is the number of writes queued for the validator command
+// before the data passed to restic waits for it to read.
+const validatorQueueSize = 64
+
+// validatorStallTimeout is how long the data passed to restic waits for the
+// validator command to read before it is considered to have stopped reading.
+const validatorStallTimeout = 10 * time.Second
+
+// stdinValidator gets a copy of the data passed from the stdin command to
+// restic and validates it according to the backup's stdin validation. The
+// validator command is fed by a goroutine so it can't block restic. Its input
+// is dropped once it exits or stops reading.
+type stdinValidator struct {
+	validation   StdinValidation
+	size         uint64
+	tail         []byte
+	command      *exec.Cmd
+	commandIn    io.WriteCloser
+	input        chan []byte
+	fed          chan struct{}
+	stallTimeout time.Duration
+	stalled      bool
+}
+
+func newStdinValidator(
+	validation StdinValidation, environment map[string]string, resources Resources,
+) (*stdinValidator, error) {
+	v := &stdinValidator{
+		validation:   validation,
+		stallTimeout: validatorStallTimeout,
+	}
+
+	if validation.Command != "" {
+		cmd, err := prepareShellCommand(validation.Command, environment, resources)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		v.commandIn, err = cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		v.command = cmd
+		v.input = make(chan []byte, validatorQueueSize)
+		v.fed = make(chan struct{})
+
+		go v.feedCommand()
+	}
+
+	return v, nil
+}
+
+// feedCommand writes the queued data to the validator command. Once writing
+// fails e.g. because the command exited, the remaining data is dropped.
+func (v *stdinValidator) feedCommand() {
+	defer close(v.fed)
+
+	var writeErr error
+
+	for data := range v.input {
+		if writeErr == nil {
+			_, writeErr = v.commandIn.Write(data)
+		}
+	}
+}
+
+// Write never fails so a failing validator command does not affect restic.
+func (v *stdinValidator) Write(data []byte) (int, error) {
+	v.size += uint64(len(data))
+
+	if v.validation.TrailingMarker != "" {
+		v.tail = append(v.tail, data...)
+		if len(v.tail) > trailingMarkerWindow {
+			v.tail = v.tail[len(v.tail)-trailingMarkerWindow:]
+		}
+	}
+
+	if v.input != nil && !v.stalled {
+		// the caller may reuse data once Write returns
+		queued := append([]byte(nil), data...)
+
+		select {
+		case v.input <- queued:
+		default:
+			timer := time.NewTimer(v.stallTimeout)
+			select {
+			case v.input <- queued:
+			case <-timer.C:
+				v.stalled = true
+			}
+			timer.Stop()
+		}
+	}
+
+	return len(data), nil
+}
+
+// Finish waits for the validator command and returns an error if any of the
+// validations failed.
+func (v *stdinValidator) Finish() error {
+
+	var commandErr error
+
+	if v.command != nil {
+		close(v.input)
+
+		if v.stalled {
+			// unblocks the pending write, the command won't get all data anyway
+			v.command.Process.Kill()
+			v.commandIn.Close()
+		}
+
+		<-v.fed
+		v.commandIn.Close()
+		commandErr = v.command.Wait()
+
+		if v.stalled {
+			commandErr = fmt.Errorf("stopped reading its input for %s", v.stallTimeout)
+		}
+	}
+
+	if v.size < v.validation.MinBytes {
+		return fmt.Errorf(
+			"stdin validation failed: got %d bytes, expected at least %d",
+			v.size, v.validation.MinBytes,
+		)
+	}
+
+	if v.validation.TrailingMarker != "" && !bytes.Contains(v.tail, []byte(v.validation.TrailingMarker)) {
+		return fmt.Errorf(
+			"stdin validation failed: trailing marker \"%s\" not found",
+			v.validation.TrailingMarker,
+		)
+	}
+
+	if commandErr != nil {
+		return fmt.Errorf("stdin validation failed: validator command: %s", commandErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdinValidatorMinBytes(t *testing.T) {
+	v, err := newStdinValidator(StdinValidation{MinBytes: 10}, nil, Resources{})
+	assert.Nil(t, err)
+
+	v.Write([]byte("12345"))
+	assert.NotNil(t, v.Finish())
+
+	v, _ = newStdinValidator(StdinValidation{MinBytes: 10}, nil, Resources{})
+	v.Write([]byte("12345"))
+	v.Write([]byte("67890"))
+	assert.Nil(t, v.Finish())
+}
+
+func TestStdinValidatorTrailingMarker(t *testing.T) {
+	validation := StdinValidation{TrailingMarker: "-- Dump completed"}
+
+	v, _ := newStdinValidator(validation, nil, Resources{})
+	v.Write([]byte("CREATE TABLE test;\n-- Dump comp"))
+	v.Write([]byte("leted on 2018-06-01 12:00:00\n"))
+	assert.Nil(t, v.Finish())
+
+	v, _ = newStdinValidator(validation, nil, Resources{})
+	v.Write([]byte("-- Dump completed on 2018-06-01 12:00:00\n"))
+	v.Write([]byte(strings.Repeat("x", trailingMarkerWindow)))
+	assert.NotNil(t, v.Finish(), "marker is not at the end of the stream")
+
+	v, _ = newStdinValidator(validation, nil, Resources{})
+	v.Write([]byte("CREATE TABLE test;\n"))
+	assert.NotNil(t, v.Finish())
+}
+
+func TestStdinValidatorCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs grep")
+	}
+
+	v, err := newStdinValidator(StdinValidation{Command: "grep -q COMPLETE"}, nil, Resources{})
+	assert.Nil(t, err)
+	v.Write([]byte("data\nCOMPLETE\n"))
+	assert.Nil(t, v.Finish())
+
+	v, err = newStdinValidator(StdinValidation{Command: "grep -q COMPLETE"}, nil, Resources{})
+	assert.Nil(t, err)
+	v.Write([]byte("data\n"))
+	assert.NotNil(t, v.Finish())
+}
+
+func TestStdinValidatorCommandNotReading(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs true and sleep")
+	}
+
+	// much more data than fits into the pipe to the command
+	data := []byte(strings.Repeat("x", 64*1024))
+
+	v, err := newStdinValidator(StdinValidation{Command: "true"}, nil, Resources{})
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		n, err := v.Write(data)
+		assert.Equal(t, len(data), n)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, v.Finish())
+
+	v, err = newStdinValidator(StdinValidation{Command: "sleep 60"}, nil, Resources{})
+	assert.Nil(t, err)
+	v.stallTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		v.Write(data)
+	}
+	err = v.Finish()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "stopped reading")
+	assert.True(t, time.Since(start) < 10*time.Second)
+}
@@ -1,25 +1,36 @@
 package internal
 
-import "path/filepath"
+import (
+	"path/filepath"
+	"sort"
+)
 
+// comparePathList reports whether a and b contain the same paths in any order.
 func comparePathList(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
-	}
+	cleanA := make([]string, len(a))
 	for i, v := range a {
-		if filepath.Clean(v) != filepath.Clean(b[i]) {
-			return false
-		}
+		cleanA[i] = filepath.Clean(v)
 	}
-	return true
+	cleanB := make([]string, len(b))
+	for i, v := range b {
+		cleanB[i] = filepath.Clean(v)
+	}
+	return compareStringList(cleanA, cleanB)
 }
 
+// compareStringList reports whether a and b contain the same strings in any order.
 func compareStringList(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
-	for i, v := range a {
-		if v != b[i] {
+
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+
+	for i, v := range sortedA {
+		if v != sortedB[i] {
 			return false
 		}
 	}
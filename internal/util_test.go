@@ -17,6 +17,8 @@ func TestComparePathList(t *testing.T) {
 	assert.False(t, comparePathList([]string{"/test1", "/test2"}, []string{}))
 
 	assert.False(t, comparePathList([]string{"/test1", "/test2"}, []string{"/test1", "/testX"}))
+
+	assert.True(t, comparePathList([]string{"/test1", "/test2/"}, []string{"/test2", "/test1"}))
 }
 
 func TestCompareStringList(t *testing.T) {
@@ -30,6 +32,9 @@ func TestCompareStringList(t *testing.T) {
 	assert.False(t, compareStringList([]string{"a", "b", "c"}, []string{}))
 
 	assert.False(t, compareStringList([]string{"a", "b", "c"}, []string{"a", "b", "x"}))
+
+	assert.True(t, compareStringList([]string{"a", "b", "c"}, []string{"c", "a", "b"}))
+	assert.False(t, compareStringList([]string{"a", "a", "b"}, []string{"a", "b", "b"}))
 }
 
 func TestCombineMaps(t *testing.T) {
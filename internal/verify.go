@@ -0,0 +1,436 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrVerifyFailed is returned if restored data differs from the live data or
+// the checksum recorded at backup time.
+var ErrVerifyFailed = errors.New("verify failed")
+
+// VerifyResult is the outcome of a restore drill. Files changed since the
+// backup can't be compared with the live filesystem and are only counted.
+type VerifyResult struct {
+	Files    int
+	Sources  int
+	Changed  int
+	Problems []string
+}
+
+// snapshotNode is a file or directory as listed by "restic ls --json".
+type snapshotNode struct {
+	Type    string      `json:"type"`
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// Verify restores a sample of the files of the latest snapshot of the backup
+// and compares them with the live filesystem. Stdin sources are restored
+// completely and compared with the checksum recorded at backup time.
+func (r Restic) Verify(backup Backup, repository Repository) (VerifyResult, error) {
+
+	repository = backup.overrideRepository(repository)
+	environment := combineMaps(repository.Environment, backup.Environment)
+
+	if err := r.prepareLocks(repository); err != nil {
+		r.dumpUnlockError(repository, err)
+		r.runHandlerVerifyFailure(backup, repository, environment, err)
+		return VerifyResult{}, err
+	}
+
+	result, err := r.verify(backup, repository)
+
+	if err == nil && len(result.Problems) == 1 {
+		err = fmt.Errorf("%w: %s", ErrVerifyFailed, result.Problems[0])
+	} else if err == nil && len(result.Problems) > 1 {
+		err = fmt.Errorf("%w: %s (%d problems)", ErrVerifyFailed, result.Problems[0], len(result.Problems))
+	}
+
+	if err != nil {
+		r.runHandlerVerifyFailure(backup, repository, environment, err)
+	} else {
+		r.runHandler(backup.Handler.VerifySuccess, "verify_success", environment, newHandlerArgs(&backup, &repository))
+	}
+
+	return result, err
+}
+
+func (r Restic) runHandlerVerifyFailure(backup Backup, repository Repository, environment map[string]string, err error) {
+	r.runHandler(backup.Handler.VerifyFailure, "verify_failure", environment, newHandlerArgs(&backup, &repository).withError(err))
+}
+
+func (r Restic) verify(backup Backup, repository Repository) (VerifyResult, error) {
+
+	var result VerifyResult
+
+	snapshots, err := r.listSnapshots(repository, backup.Environment)
+	if err != nil || r.dryRun {
+		return result, err
+	}
+
+	hostname, err := backup.GetHost()
+	if err != nil {
+		return result, err
+	}
+
+	sources := backup.GetStdinSources()
+
+	if len(sources) == 0 {
+		snapshot := latestDataSnapshot(backup, snapshots, hostname)
+		if snapshot == nil {
+			result.Problems = append(result.Problems, "no snapshot found")
+			return result, nil
+		}
+		return r.verifyDataSnapshot(backup, repository, *snapshot)
+	}
+
+	checksums, err := loadChecksums(r.stateDirectory)
+	if err != nil {
+		return result, err
+	}
+
+	for _, source := range sources {
+		if r.isInterrupted() {
+			return result, fmt.Errorf("%w before verifying %s", ErrInterrupted, source.Filename)
+		}
+
+		snapshot := latestSourceSnapshot(backup, source, snapshots, hostname)
+		if snapshot == nil {
+			result.Problems = append(result.Problems, fmt.Sprintf("no snapshot of %s found", source.Filename))
+			continue
+		}
+
+		problem, err := r.verifyStdinSnapshot(backup, repository, *snapshot, checksums)
+		if err != nil {
+			return result, err
+		}
+
+		if problem != "" {
+			result.Problems = append(result.Problems, problem)
+		} else {
+			result.Sources++
+		}
+	}
+
+	return result, nil
+}
+
+// verifyStdinSnapshot restores the data of a stdin source and compares its
+// checksum. Without a recorded checksum it only proves the data is readable.
+func (r Restic) verifyStdinSnapshot(
+	backup Backup, repository Repository, snapshot Snapshot, checksums map[string]checksumRecord,
+) (string, error) {
+
+	filename := snapshot.Paths[0]
+
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+	cmd.Args = append(cmd.Args, "dump", snapshot.ID, filename)
+
+	checksum := sha256.New()
+	cmd.Stdout = checksum
+
+	err := r.runRestic(
+		fmt.Sprintf("restore %s of snapshot %s of backup [%s]", filename, shortID(snapshot.ID), backup.Name),
+		cmd,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	record, ok := checksums[snapshot.ID]
+	if !ok && snapshot.Original != "" {
+		record, ok = checksums[snapshot.Original]
+	}
+
+	if !ok {
+		fmt.Fprintf(
+			os.Stderr, "No checksum recorded for snapshot %s of backup [%s], only checked %s can be restored\n",
+			shortID(snapshot.ID), backup.Name, filename,
+		)
+		return "", nil
+	}
+
+	if record.SHA256 != hex.EncodeToString(checksum.Sum(nil)) {
+		return fmt.Sprintf("%s: checksum differs from the one recorded at backup time", filename), nil
+	}
+
+	return "", nil
+}
+
+// verifyDataSnapshot restores a sample of the files of the snapshot into a
+// temporary directory and compares them with the live files.
+func (r Restic) verifyDataSnapshot(backup Backup, repository Repository, snapshot Snapshot) (VerifyResult, error) {
+
+	var result VerifyResult
+
+	nodes, err := r.listFiles(backup, repository, snapshot)
+	if err != nil {
+		return result, err
+	}
+
+	sample := sampleFiles(nodes, backup.Verify)
+	if len(sample) == 0 {
+		return result, nil
+	}
+
+	target, err := ioutil.TempDir("", "rester-verify-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(target)
+
+	// a single --include per file might exceed the maximum command line length
+	includeFile, err := writeIncludeFile(sample)
+	if err != nil {
+		return result, err
+	}
+	defer os.Remove(includeFile)
+
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+	cmd.Args = append(cmd.Args, "restore", snapshot.ID, "--target", target, "--include-file", includeFile)
+
+	r.streamOutput(cmd)
+
+	err = r.runRestic(
+		fmt.Sprintf("restore %d files of snapshot %s of backup [%s]", len(sample), shortID(snapshot.ID), backup.Name),
+		cmd,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	for _, node := range sample {
+		livePath := backup.SnapshotProvider.unmapPaths([]string{node.Path})[0]
+		restoredPath := filepath.Join(target, filepath.FromSlash(node.Path))
+
+		changed, problem := compareRestoredFile(node, restoredPath, livePath)
+
+		if changed {
+			result.Changed++
+		} else if problem != "" {
+			result.Problems = append(result.Problems, fmt.Sprintf("%s: %s", livePath, problem))
+		} else {
+			result.Files++
+		}
+	}
+
+	if result.Files == 0 && result.Changed > 0 {
+		result.Problems = append(
+			result.Problems,
+			fmt.Sprintf("all %d sampled files changed since the backup, none was verified", result.Changed),
+		)
+	}
+
+	return result, nil
+}
+
+// writeIncludeFile writes a pattern matching each sampled file to a temporary
+// file for restic's --include-file and returns its path.
+func writeIncludeFile(sample []snapshotNode) (string, error) {
+
+	f, err := ioutil.TempFile("", "rester-verify-include-")
+	if err != nil {
+		return "", err
+	}
+
+	for _, node := range sample {
+		if _, err := fmt.Fprintln(f, includeFilePattern(node.Path)); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return "", err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+// listFiles returns all regular files of the snapshot.
+func (r Restic) listFiles(backup Backup, repository Repository, snapshot Snapshot) ([]snapshotNode, error) {
+
+	cmd := r.prepareResticCommand(repository, backup.Environment)
+	cmd.Args = append(cmd.Args, "ls", "--json", snapshot.ID)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+
+	r.logStep("list files of snapshot %s of backup [%s]: %s", shortID(snapshot.ID), backup.Name, formatCommandLine(cmd.Args))
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var nodes []snapshotNode
+	var decodeErr error
+
+	decoder := json.NewDecoder(stdout)
+	for {
+		var node snapshotNode
+		if decodeErr = decoder.Decode(&node); decodeErr != nil {
+			break
+		}
+		if node.Type == "file" && node.Path != "" {
+			nodes = append(nodes, node)
+		}
+	}
+
+	// read the remaining output so restic doesn't block on a full pipe
+	io.Copy(ioutil.Discard, stdout)
+
+	if err := resticError(cmd.Wait(), stderr.String()); err != nil {
+		return nil, err
+	}
+
+	if decodeErr != io.EOF {
+		return nil, decodeErr
+	}
+
+	return nodes, nil
+}
+
+// sampleFiles picks random files according to the verify settings.
+func sampleFiles(nodes []snapshotNode, verify BackupVerify) []snapshotNode {
+
+	count := verify.Files
+	if verify.Percentage > 0 {
+		count = int(math.Ceil(float64(len(nodes)) * float64(verify.Percentage) / 100.0))
+	}
+
+	if count > len(nodes) {
+		count = len(nodes)
+	}
+
+	sample := make([]snapshotNode, len(nodes))
+	copy(sample, nodes)
+
+	rand.Shuffle(len(sample), func(i, j int) {
+		sample[i], sample[j] = sample[j], sample[i]
+	})
+
+	sample = sample[:count]
+
+	sort.Slice(sample, func(i, j int) bool {
+		return sample[i].Path < sample[j].Path
+	})
+
+	return sample
+}
+
+// escapePattern escapes a path so restic's --include matches it literally.
+func escapePattern(path string) string {
+	var escaped strings.Builder
+
+	for _, c := range path {
+		if strings.ContainsRune(`\*?[`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+
+	return escaped.String()
+}
+
+// includeFilePattern returns the pattern matching a path in a file read by
+// restic's --include-file. Restic reads the file line by line, trims white
+// space and expands environment variables. Characters it would change are
+// matched by a wildcard instead.
+func includeFilePattern(path string) string {
+	pattern := []rune(escapePattern(path))
+
+	for i, c := range pattern {
+		if c == '$' || c == '\n' || c == '\r' {
+			pattern[i] = '?'
+		}
+	}
+
+	for i := len(pattern) - 1; i >= 0 && unicode.IsSpace(pattern[i]); i-- {
+		pattern[i] = '?'
+	}
+
+	return string(pattern)
+}
+
+// compareRestoredFile compares a restored file with the live one. Files
+// changed since the backup according to size and modification time are
+// reported as changed instead of being compared.
+func compareRestoredFile(node snapshotNode, restoredPath string, livePath string) (bool, string) {
+
+	live, err := os.Stat(livePath)
+	if err != nil || live.Size() != node.Size || !live.ModTime().Equal(node.ModTime) {
+		return true, ""
+	}
+
+	restored, err := os.Stat(restoredPath)
+	if err != nil {
+		return false, "not restored"
+	}
+
+	if restored.Size() != live.Size() {
+		return false, fmt.Sprintf("size %d differs from live size %d", restored.Size(), live.Size())
+	}
+
+	if restored.Mode() != live.Mode() {
+		return false, fmt.Sprintf("mode %s differs from live mode %s", restored.Mode(), live.Mode())
+	}
+
+	// the temporary directory may store modification times less precisely
+	if !restored.ModTime().Truncate(time.Second).Equal(live.ModTime().Truncate(time.Second)) {
+		return false, "modification time differs"
+	}
+
+	restoredChecksum, err := fileChecksum(restoredPath)
+	if err != nil {
+		return false, fmt.Sprintf("failed to read restored file: %s", err)
+	}
+
+	liveChecksum, err := fileChecksum(livePath)
+	if err != nil {
+		return false, fmt.Sprintf("failed to read live file: %s", err)
+	}
+
+	if restoredChecksum != liveChecksum {
+		return false, "content differs"
+	}
+
+	return false, ""
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	checksum := sha256.New()
+	if _, err := io.Copy(checksum, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(checksum.Sum(nil)), nil
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleFiles(t *testing.T) {
+	var nodes []snapshotNode
+	for _, path := range []string{"/e", "/d", "/c", "/b", "/a"} {
+		nodes = append(nodes, snapshotNode{Type: "file", Path: path})
+	}
+
+	sample := sampleFiles(nodes, BackupVerify{Files: 3})
+	assert.Len(t, sample, 3)
+	assert.True(t, sample[0].Path < sample[1].Path && sample[1].Path < sample[2].Path)
+
+	assert.Len(t, sampleFiles(nodes, BackupVerify{Files: 10}), 5)
+	assert.Len(t, sampleFiles(nodes, BackupVerify{Percentage: 30}), 2)
+	assert.Len(t, sampleFiles(nodes, BackupVerify{Percentage: 100}), 5)
+	assert.Len(t, sampleFiles(nil, BackupVerify{Files: 3}), 0)
+}
+
+func TestEscapePattern(t *testing.T) {
+	assert.Equal(t, "/home/test/file.txt", escapePattern("/home/test/file.txt"))
+	assert.Equal(t, `/home/test/we\*ird\[1]\?.txt`, escapePattern("/home/test/we*ird[1]?.txt"))
+	assert.Equal(t, `/home/?HOME/\*?and?line?`, includeFilePattern("/home/$HOME/*$and\nline "))
+}
+
+func TestCompareRestoredFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rester-verify-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	live := filepath.Join(dir, "live.txt")
+	restored := filepath.Join(dir, "restored.txt")
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	write := func(path string, content string) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	write(live, "content")
+	write(restored, "content")
+
+	node := snapshotNode{Type: "file", Path: live, Size: 7, ModTime: mtime}
+
+	changed, problem := compareRestoredFile(node, restored, live)
+	assert.False(t, changed)
+	assert.Equal(t, "", problem)
+
+	write(restored, "CONTENT")
+	changed, problem = compareRestoredFile(node, restored, live)
+	assert.False(t, changed)
+	assert.Equal(t, "content differs", problem)
+
+	assert.NoError(t, os.Chmod(restored, 0644))
+	_, problem = compareRestoredFile(node, restored, live)
+	assert.Contains(t, problem, "mode")
+
+	changed, problem = compareRestoredFile(node, filepath.Join(dir, "missing"), live)
+	assert.False(t, changed)
+	assert.Equal(t, "not restored", problem)
+
+	// changed since the backup
+	write(live, "new content")
+	changed, problem = compareRestoredFile(node, restored, live)
+	assert.True(t, changed)
+	assert.Equal(t, "", problem)
+}